@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// tournaments are played from a separate bank, so their results are
+// reported by mode but don't move the main bank
+const backtestTournBank = tournBailout
+
+type roi struct {
+	Bets, Hits      int
+	Wagered, Profit float64
+}
+
+func (r *roi) add(amount, change float64, hit bool) {
+	r.Bets++
+	if hit {
+		r.Hits++
+	}
+	r.Wagered += amount
+	r.Profit += change
+}
+
+func (r *roi) HitRate() float64 {
+	if r.Bets == 0 {
+		return 0
+	}
+	return float64(r.Hits) / float64(r.Bets)
+}
+
+func (r *roi) ROI() float64 {
+	if r.Wagered == 0 {
+		return 0
+	}
+	return r.Profit / r.Wagered
+}
+
+type backtestResult struct {
+	Start, Bank      float64
+	Peak             float64
+	MaxDrawdown      float64
+	Matches, Skipped int
+	Total            roi
+	ByTier, ByMode   map[string]*roi
+}
+
+func (r *backtestResult) settle(rec *matchRecord, amount, change float64, hit bool) {
+	r.Total.add(amount, change, hit)
+	for _, g := range []struct {
+		m   map[string]*roi
+		key string
+	}{{r.ByTier, rec.Tier}, {r.ByMode, rec.Mode}} {
+		if g.m[g.key] == nil {
+			g.m[g.key] = new(roi)
+		}
+		g.m[g.key].add(amount, change, hit)
+	}
+}
+
+// buildTiers returns fresh per-tier stats from recs
+func buildTiers(recs []*matchRecord) (ts [5]*tierData) {
+	byTier := make(map[string][]*matchRecord)
+	for _, rec := range recs {
+		byTier[rec.Tier] = append(byTier[rec.Tier], rec)
+	}
+	for tier, i := range tierIdx {
+		ts[i] = &tierData{chars: make(charStatsMap)}
+		ts[i].Update(byTier[tier])
+	}
+	return
+}
+
+// runBacktest bets on recs in order starting from stats built from history,
+// using the live betting rules. Each result is added to the stats after the
+// match settles, as watchAndRun does, but the Bradley-Terry fit is only redone
+// every btEvery matches in a tier.
+func runBacktest(pred predictor, sz sizer, history, recs []*matchRecord, bank float64, btEvery int, out *csv.Writer) *backtestResult {
+	ts := buildTiers(history)
+	for _, d := range ts {
+		d.btEvery = btEvery
+	}
+	res := &backtestResult{
+		Start:  bank,
+		Bank:   bank,
+		Peak:   bank,
+		ByTier: make(map[string]*roi),
+		ByMode: make(map[string]*roi),
+	}
+	if out != nil {
+		out.Write([]string{"match_id", "ts", "tier", "mode", "name1", "name2", "pick", "winner", "wager", "change", "bank"})
+	}
+	var tbank float64
+	lastMode := ""
+	for _, rec := range recs {
+		res.Matches++
+		d := ts[tierIdx[rec.Tier]]
+		tourn := rec.Mode == "tournament"
+		if tourn && lastMode != "tournament" {
+			tbank = backtestTournBank
+		}
+		lastMode = rec.Mode
+		cur, bailout := &res.Bank, float64(defaultBailout)
+		if tourn {
+			cur, bailout = &tbank, tournBailout
+		}
+		var amount, change float64
+		pick := -1
+		if wg, _, ok := pred.Wager(d, rec, *cur); ok {
+			if p, amt, ok := sz.Bet(d, rec, wg, *cur); ok {
+				amount, pick = amt, p
+			}
+		}
+		if pick < 0 {
+			res.Skipped++
+		} else {
+			hit := pick == rec.Winner
+			change = -amount
+			if hit {
+				change = rec.Payoff(amount)
+			}
+			*cur += change
+			if *cur < bailout {
+				*cur = bailout
+			}
+			res.settle(rec, amount, change, hit)
+		}
+		if res.Bank > res.Peak {
+			res.Peak = res.Bank
+		} else if dd := (res.Peak - res.Bank) / res.Peak; dd > res.MaxDrawdown {
+			res.MaxDrawdown = dd
+		}
+		if out != nil {
+			pickName := ""
+			if pick >= 0 {
+				pickName = rec.Name[pick]
+			}
+			out.Write([]string{
+				rec.ID, rec.TS.UTC().Format(time.RFC3339), rec.Tier, rec.Mode, rec.Name[0], rec.Name[1],
+				pickName, rec.Name[rec.Winner],
+				strconv.FormatFloat(amount, 'f', 0, 64),
+				strconv.FormatFloat(change, 'f', 0, 64),
+				strconv.FormatFloat(*cur, 'f', 0, 64),
+			})
+		}
+		d.Update([]*matchRecord{rec})
+	}
+	if out != nil {
+		out.Flush()
+	}
+	return res
+}
+
+func (r *backtestResult) Print() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "start bank\t%s\n", fmtNum(r.Start))
+	fmt.Fprintf(w, "final bank\t%s\n", fmtNum(r.Bank))
+	fmt.Fprintf(w, "peak bank\t%s\n", fmtNum(r.Peak))
+	fmt.Fprintf(w, "max drawdown\t%.1f%%\n", r.MaxDrawdown*100)
+	fmt.Fprintf(w, "matches\t%d (%d skipped)\n", r.Matches, r.Skipped)
+	fmt.Fprintf(w, "hit rate\t%.1f%%\n", r.Total.HitRate()*100)
+	fmt.Fprintf(w, "ROI\t%+.2f%%\n\n", r.Total.ROI()*100)
+	fmt.Fprintln(w, "GROUP\tBETS\tHIT RATE\tWAGERED\tPROFIT\tROI")
+	printGroups := func(kind string, m map[string]*roi, keys []string) {
+		for _, key := range keys {
+			if g := m[key]; g != nil {
+				fmt.Fprintf(w, "%s %s\t%d\t%.1f%%\t%s\t%s\t%+.2f%%\n", kind, key, g.Bets, g.HitRate()*100, fmtNum(g.Wagered), fmtNum(g.Profit), g.ROI()*100)
+			}
+		}
+	}
+	printGroups("tier", r.ByTier, tierOrder())
+	var modes []string
+	for mode := range r.ByMode {
+		modes = append(modes, mode)
+	}
+	sort.Strings(modes)
+	printGroups("mode", r.ByMode, modes)
+	w.Flush()
+}
+
+func parseDay(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation("2006-01-02", s, time.UTC)
+}
+
+// allRecords returns every matchmaking record in the order they were played
+func allRecords() []*matchRecord {
+	tierRecs, _, _, err := getRecords("all_matches", time.Time{}, 0, false)
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+	return sortedRecords(tierRecs)
+}
+
+// recordsUntil returns the records played no later than until, or all of them
+// if it's zero
+func recordsUntil(recs []*matchRecord, until time.Time) []*matchRecord {
+	if until.IsZero() {
+		return recs
+	}
+	var kept []*matchRecord
+	for _, rec := range recs {
+		if !rec.TS.After(until) {
+			kept = append(kept, rec)
+		}
+	}
+	return kept
+}
+
+// sortedRecords returns every tier's records in the order they were played
+func sortedRecords(tierRecs map[string][]*matchRecord) []*matchRecord {
+	var recs []*matchRecord
+	for _, trecs := range tierRecs {
+		recs = append(recs, trecs...)
+	}
+	sort.SliceStable(recs, func(i, j int) bool { return recs[i].TS.Before(recs[j].TS) })
+	return recs
+}
+
+func backtestCmd(args []string) {
+	fs := flag.NewFlagSet("backtest", flag.ExitOnError)
+	predName := fs.String("predictor", viper.GetString("predictor"), "predictor to test: net, bt or ensemble")
+	netPath := fs.String("net", "_bnet", "saved network, or directory to load the best one from")
+	fromDay := fs.String("from", "", "first day to bet on, YYYY-MM-DD")
+	toDay := fs.String("to", "", "last day to bet on, YYYY-MM-DD")
+	bank := fs.Float64("bank", whaleStart, "starting bank")
+	tourn := fs.Bool("tournament", false, "also bet on tournament matches")
+	csvPath := fs.String("csv", "", "write per-match results to this file")
+	btEvery := fs.Int("bt-every", 20, "matches per tier between Bradley-Terry refits; 1 refits after every match like live betting")
+	record := fs.Bool("record", false, "store the results with the model in its registry")
+	fs.Parse(args)
+
+	from, err := parseDay(*fromDay)
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+	to, err := parseDay(*toDay)
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+	if *record && *predName != "" && *predName != "net" {
+		log.Fatalln("error: -record needs a single network")
+	}
+	var reg *registry
+	var e *modelEntry
+	if *record {
+		// test the entry's own file in case another model is promoted meanwhile
+		reg, e, err = registryEntry(*netPath)
+		if err != nil {
+			log.Fatalln("error:", err)
+		}
+		*netPath = filepath.Join(reg.dir, e.File)
+	}
+	pred, err := loadPredictor(*predName, *netPath)
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+	tierRecs, _, _, err := getRecords("all_matches", time.Time{}, 0, *tourn)
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+	var history, recs []*matchRecord
+	for _, rec := range sortedRecords(tierRecs) {
+		switch {
+		case rec.TS.Before(from):
+			history = append(history, rec)
+		case to.IsZero() || rec.TS.Before(to.AddDate(0, 0, 1)):
+			recs = append(recs, rec)
+		}
+	}
+	if len(recs) == 0 {
+		log.Fatalln("error: no matches in range")
+	}
+	sz, err := loadSizer(pred, *netPath, func() []*matchRecord { return history })
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+	log.Printf("backtesting %d matches with stats from %d earlier matches", len(recs), len(history))
+
+	var out *csv.Writer
+	if *csvPath != "" {
+		f, err := os.Create(*csvPath)
+		if err != nil {
+			log.Fatalln("error:", err)
+		}
+		defer f.Close()
+		out = csv.NewWriter(f)
+	}
+	res := runBacktest(pred, sz, history, recs, *bank, *btEvery, out)
+	res.Print()
+	if out != nil {
+		if err := out.Error(); err != nil {
+			log.Fatalln("error:", err)
+		}
+	}
+	if *record {
+		err = reg.Update(e.File, func(e *modelEntry) {
+			if e.Metrics == nil {
+				e.Metrics = make(map[string]float64)
+			}
+			e.Metrics["backtest_final_bank"] = res.Bank
+			e.Metrics["backtest_max_drawdown"] = res.MaxDrawdown
+			e.Metrics["backtest_hit_rate"] = res.Total.HitRate()
+			e.Metrics["backtest_roi"] = res.Total.ROI()
+			e.Metrics["backtest_matches"] = float64(res.Matches)
+		})
+		if err != nil {
+			log.Fatalln("error:", err)
+		}
+		log.Printf("recorded results for %s", e.File)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math"
+	"testing"
+	"time"
+)
+
+// favoriteSizer bets a fixed amount on one character whenever they play
+type favoriteSizer struct {
+	name   string
+	amount float64
+}
+
+func (s favoriteSizer) Bet(d *tierData, rec *matchRecord, wg wager, bank float64) (int, float64, bool) {
+	for i, name := range rec.Name {
+		if name == s.name {
+			return i, s.amount, true
+		}
+	}
+	return 0, 0, false
+}
+
+func TestRunBacktest(t *testing.T) {
+	base := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	var recs []*matchRecord
+	for i, m := range []struct {
+		tier, mode, winner, loser string
+	}{
+		{"A", "matchmaking", "Ryu", "Ken"},
+		{"B", "matchmaking", "Ken", "Ryu"},
+		{"A", "matchmaking", "Guile", "Ryu"},
+		{"B", "matchmaking", "Ken", "Guile"},
+		{"A", "tournament", "Ryu", "Ken"},
+		{"A", "tournament", "Guile", "Ryu"},
+		{"A", "tournament", "Ken", "Ryu"},
+	} {
+		// a winning bet of 100 collects 150
+		rec := newRecord(m.tier, m.winner, m.loser, 100, 300, 400, 60)
+		rec.Mode = m.mode
+		rec.TS = base.Add(time.Duration(i) * time.Minute)
+		recs = append(recs, rec)
+	}
+	var buf bytes.Buffer
+	res := runBacktest(fixedWager(0.5), favoriteSizer{"Ryu", 100}, nil, recs, 1000, 1, csv.NewWriter(&buf))
+
+	near := func(what string, got, want float64) {
+		t.Helper()
+		if math.Abs(got-want) > 1e-9 {
+			t.Errorf("%s = %v, want %v", what, got, want)
+		}
+	}
+	// tournament results don't move the main bank
+	near("final bank", res.Bank, 950)
+	near("peak", res.Peak, 1150)
+	near("max drawdown", res.MaxDrawdown, 200.0/1150)
+	if res.Matches != 7 || res.Skipped != 1 || res.Total.Bets != 6 || res.Total.Hits != 2 {
+		t.Errorf("matches = %d, skipped %d, bets %d, hits %d", res.Matches, res.Skipped, res.Total.Bets, res.Total.Hits)
+	}
+	for _, c := range []struct {
+		group string
+		r     *roi
+		roi   float64
+	}{
+		{"tier A", res.ByTier["A"], 0},
+		{"tier B", res.ByTier["B"], -1},
+		{"matchmaking", res.ByMode["matchmaking"], -50.0 / 300},
+		{"tournament", res.ByMode["tournament"], -50.0 / 300},
+	} {
+		if c.r == nil {
+			t.Errorf("no results for %s", c.group)
+			continue
+		}
+		near(c.group+" ROI", c.r.ROI(), c.roi)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != len(recs)+1 {
+		t.Fatalf("got %d CSV rows", len(rows))
+	}
+	// the tournament bank starts at its bailout and never falls below it
+	for i, want := range []string{"1150", "1050", "950", "950", "1600", "1500", "1450"} {
+		if got := rows[i+1][10]; got != want {
+			t.Errorf("bank after match %d = %s, want %s", i+1, got, want)
+		}
+	}
+}
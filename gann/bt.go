@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// Bradley-Terry fit over every matchup in a tier, using the MM algorithm from
+// Hunter (2004). Each character also plays btPrior wins and losses against an
+// average reference so that undefeated or winless characters stay finite.
+const (
+	btIterations = 500
+	btTolerance  = 1e-6
+	btPrior      = 1.0
+	btZ          = 1.96
+)
+
+type btStrength struct {
+	Theta, StdErr float64
+	Games         float64
+}
+
+func (s btStrength) Interval() (float64, float64) {
+	return s.Theta - btZ*s.StdErr, s.Theta + btZ*s.StdErr
+}
+
+// btProb returns the probability that a beats b
+func btProb(a, b btStrength) float64 {
+	return 1 / (1 + math.Exp(b.Theta-a.Theta))
+}
+
+func fitBT(cm charStatsMap) map[string]btStrength {
+	gamma := make(map[string]float64, len(cm))
+	for name := range cm {
+		gamma[name] = 1
+	}
+	next := make(map[string]float64, len(cm))
+	for i := 0; i < btIterations; i++ {
+		var change float64
+		for name, s := range cm {
+			g := gamma[name]
+			denom := 2 * btPrior / (g + 1)
+			for opp, m := range s.matchups {
+				denom += (m.Wins + m.Losses) / (g + gamma[opp])
+			}
+			next[name] = (s.Wins + btPrior) / denom
+			change = math.Max(change, math.Abs(next[name]-g)/g)
+		}
+		gamma, next = next, gamma
+		if change < btTolerance {
+			break
+		}
+	}
+	fit := make(map[string]btStrength, len(cm))
+	for name, s := range cm {
+		// standard error from the diagonal of the Fisher information
+		g := gamma[name]
+		info := 2 * btPrior * g / ((g + 1) * (g + 1))
+		for opp, m := range s.matchups {
+			p := g / (g + gamma[opp])
+			info += (m.Wins + m.Losses) * p * (1 - p)
+		}
+		fit[name] = btStrength{
+			Theta:  math.Log(g),
+			StdErr: 1 / math.Sqrt(info),
+			Games:  s.Wins + s.Losses,
+		}
+	}
+	return fit
+}
+
+// bt returns the tier's Bradley-Terry fit, refitting after new results
+func (d *tierData) bt() map[string]btStrength {
+	d.tmu.Lock()
+	fit, version := d.btFit, d.version
+	d.tmu.Unlock()
+	if fit != nil {
+		return fit
+	}
+	fit = fitBT(d.chars)
+	d.tmu.Lock()
+	defer d.tmu.Unlock()
+	if d.version == version {
+		d.btFit = fit
+	}
+	return fit
+}
+
+func (d *tierData) btProb(rec *matchRecord) float64 {
+	fit := d.bt()
+	return btProb(fit[rec.Name[0]], fit[rec.Name[1]])
+}
+
+// btPredictor bets on the Bradley-Terry favorite, staking more the further
+// its win probability is from a coin flip
+type btPredictor struct{}
+
+func (btPredictor) Wager(d *tierData, rec *matchRecord, bank float64) (wager, []float64, bool) {
+	if d.chars[rec.Name[0]] == nil || d.chars[rec.Name[1]] == nil {
+		return 0, nil, false
+	}
+	p := d.btProb(rec)
+	return wager(0.5 - p), []float64{p}, true
+}
+
+// printBT prints each character's fitted strength with a 95% interval
+func printBT(args []string) {
+	prepData(false, time.Time{})
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TIER\tNAME\tGAMES\tSTRENGTH\tLOW\tHIGH")
+	var found bool
+	for _, tier := range tierOrder() {
+		if len(args) > 0 && args[0] != tier {
+			continue
+		}
+		found = true
+		fit := tiers[tierIdx[tier]].bt()
+		names := make([]string, 0, len(fit))
+		for name := range fit {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool { return fit[names[i]].Theta > fit[names[j]].Theta })
+		for _, name := range names {
+			s := fit[name]
+			lo, hi := s.Interval()
+			fmt.Fprintf(w, "%s\t%s\t%.0f\t%+.3f\t%+.3f\t%+.3f\n", tier, name, s.Games, s.Theta, lo, hi)
+		}
+	}
+	w.Flush()
+	if !found {
+		log.Fatalln("error: unknown tier", args[0])
+	}
+}
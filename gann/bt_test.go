@@ -0,0 +1,37 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFitBT(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		games [][2]string
+		theta map[string]float64
+	}{
+		{"even", [][2]string{{"a", "b"}, {"b", "a"}}, map[string]float64{"a": 0, "b": 0}},
+		// expected values are the MAP estimates found by gradient ascent
+		{"three to one", [][2]string{{"a", "b"}, {"a", "b"}, {"a", "b"}, {"b", "a"}}, map[string]float64{"a": 0.4196, "b": -0.4196}},
+		{"chain", [][2]string{{"a", "b"}, {"a", "b"}, {"b", "c"}, {"b", "c"}}, map[string]float64{"a": math.Log(3), "b": 0, "c": -math.Log(3)}},
+		// the prior keeps an undefeated character finite
+		{"undefeated", [][2]string{{"a", "b"}, {"a", "b"}, {"a", "b"}}, map[string]float64{"a": 0.9032, "b": -0.9032}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			cm := make(charStatsMap)
+			for _, g := range c.games {
+				cm.Update([]*matchRecord{newRecord("A", g[0], g[1], 1, 1, 2, 60)})
+			}
+			fit := fitBT(cm)
+			for name, want := range c.theta {
+				if got := fit[name].Theta; math.Abs(got-want) > 1e-3 {
+					t.Errorf("theta[%s] = %.4f, want %.4f", name, got, want)
+				}
+				if fit[name].StdErr <= 0 || math.IsInf(fit[name].StdErr, 0) {
+					t.Errorf("stderr[%s] = %v", name, fit[name].StdErr)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// calibrationWindow is how many of the most recent matches the
+	// calibration is fitted to
+	calibrationWindow = 5000
+	// calibrationRefresh is how many matches can be played after a saved
+	// calibration before it is fitted again
+	calibrationRefresh = 1000
+	// minCalibrationSamples is the fewest predictions a calibration can be
+	// fitted to
+	minCalibrationSamples = 200
+)
+
+// calibration is a fit saved with a model, and the last match it saw
+type calibration struct {
+	platt
+	DataTo time.Time
+}
+
+// platt maps a predictor's raw output to the probability that the second
+// character wins, using Platt scaling
+type platt struct {
+	A, B float64
+}
+
+func (c platt) Prob(wg wager) float64 {
+	return 1 / (1 + math.Exp(-(c.A*float64(wg) + c.B)))
+}
+
+// fitPlatt fits a logistic curve to outputs xs and outcomes ys by Newton's
+// method, with Platt's smoothed targets so that a perfectly separated sample
+// doesn't produce infinite confidence
+func fitPlatt(xs []float64, ys []bool) platt {
+	var pos, neg float64
+	for _, y := range ys {
+		if y {
+			pos++
+		} else {
+			neg++
+		}
+	}
+	hi, lo := (pos+1)/(pos+2), 1/(neg+2)
+	var c platt
+	for iter := 0; iter < 100; iter++ {
+		// gradient and hessian of the log loss
+		var ga, gb, haa, hab, hbb float64
+		for i, x := range xs {
+			t := lo
+			if ys[i] {
+				t = hi
+			}
+			p := c.Prob(wager(x))
+			d := p - t
+			w := p * (1 - p)
+			ga += d * x
+			gb += d
+			haa += w * x * x
+			hab += w * x
+			hbb += w
+		}
+		// small ridge term keeps the hessian invertible
+		haa += 1e-9
+		hbb += 1e-9
+		det := haa*hbb - hab*hab
+		if det == 0 {
+			break
+		}
+		da := (hbb*ga - hab*gb) / det
+		db := (haa*gb - hab*ga) / det
+		c.A -= da
+		c.B -= db
+		if math.Abs(da) < 1e-9 && math.Abs(db) < 1e-9 {
+			break
+		}
+	}
+	return c
+}
+
+// calibrate replays history in order with fresh stats and fits pred's raw
+// outputs on the most recent matches to their outcomes. It fails if pred made
+// too few predictions to fit.
+func calibrate(pred predictor, history []*matchRecord) (platt, error) {
+	ts := buildTiers(nil)
+	start := len(history) - calibrationWindow
+	var xs []float64
+	var ys []bool
+	for i, rec := range history {
+		d := ts[tierIdx[rec.Tier]]
+		if i >= start {
+			if wg, _, ok := pred.Wager(d, rec, whaleStart); ok {
+				xs = append(xs, float64(wg))
+				ys = append(ys, rec.Winner == 1)
+			}
+		}
+		d.Update([]*matchRecord{rec})
+	}
+	if len(xs) < minCalibrationSamples {
+		return platt{}, fmt.Errorf("only %d predictions to calibrate on, need at least %d", len(xs), minCalibrationSamples)
+	}
+	c := fitPlatt(xs, ys)
+	log.Printf("calibrated on %d matches: A=%.4f B=%.4f", len(xs), c.A, c.B)
+	return c, nil
+}
+
+// cachedCalibration returns the calibration saved with the network netPath
+// refers to, as long as history includes the matches it saw and not too many
+// more. Otherwise it calibrates pred and saves the result. Predictors other
+// than a registered network are always calibrated from scratch.
+func cachedCalibration(pred predictor, netPath string, history []*matchRecord) (platt, error) {
+	if _, ok := pred.(betNet); !ok || len(history) == 0 {
+		return calibrate(pred, history)
+	}
+	reg, e, err := registryEntry(netPath)
+	if err != nil {
+		return calibrate(pred, history)
+	}
+	last := history[len(history)-1].TS
+	if c := e.Calibration; c != nil && !c.DataTo.After(last) {
+		seen := sort.Search(len(history), func(i int) bool { return history[i].TS.After(c.DataTo) })
+		if len(history)-seen <= calibrationRefresh {
+			log.Printf("using calibration saved with %s: A=%.4f B=%.4f", e.File, c.A, c.B)
+			return c.platt, nil
+		}
+	}
+	c, err := calibrate(pred, history)
+	if err != nil {
+		return c, err
+	}
+	err = reg.Update(e.File, func(e *modelEntry) {
+		e.Calibration = &calibration{platt: c, DataTo: last}
+	})
+	if err != nil {
+		log.Printf("error: saving calibration: %s", err)
+	}
+	return c, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestFitPlatt(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		a, b float64
+	}{
+		{"steep", 4, 0.5},
+		{"shallow", 1, -0.25},
+		{"reversed", -2, 0},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			// outcomes in exactly the proportions the curve predicts
+			want := platt{c.a, c.b}
+			var xs []float64
+			var ys []bool
+			for x := -1.0; x <= 1; x += 0.1 {
+				pos := int(math.Round(1000 * want.Prob(wager(x))))
+				for i := 0; i < 1000; i++ {
+					xs = append(xs, x)
+					ys = append(ys, i < pos)
+				}
+			}
+			got := fitPlatt(xs, ys)
+			if math.Abs(got.A-c.a) > 0.05 || math.Abs(got.B-c.b) > 0.05 {
+				t.Errorf("fitPlatt = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestFitPlattSeparated(t *testing.T) {
+	xs := []float64{-0.5, -0.25, 0.25, 0.5}
+	ys := []bool{false, false, true, true}
+	c := fitPlatt(xs, ys)
+	if math.IsInf(c.A, 0) || math.IsNaN(c.A) || c.A <= 0 {
+		t.Fatalf("fitPlatt = %+v, want a finite positive slope", c)
+	}
+	if p := c.Prob(0.5); p >= 1 {
+		t.Errorf("Prob(0.5) = %v, want less than certain", p)
+	}
+}
+
+func TestCalibrateTooFew(t *testing.T) {
+	var history []*matchRecord
+	for i := 0; i < minCalibrationSamples; i++ {
+		if _, err := calibrate(fixedWager(0.2), history); err == nil {
+			t.Fatalf("calibrated on %d matches", len(history))
+		}
+		history = append(history, newRecord("A", "Ryu", "Ken", 100, 100, 200, 60))
+	}
+	if _, err := calibrate(fixedWager(0.2), history); err != nil {
+		t.Errorf("calibrate on %d matches: %s", len(history), err)
+	}
+
+	viper.Set("sizing", "kelly")
+	defer viper.Set("sizing", "")
+	if sz, err := loadSizer(fixedWager(0.2), "", func() []*matchRecord { return nil }); err == nil {
+		t.Errorf("loadSizer without history = %+v", sz)
+	}
+}
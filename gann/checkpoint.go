@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"time"
+
+	deep "github.com/patrikeh/go-deep"
+)
+
+type rngState struct {
+	Seed, Count int64
+}
+
+// countingSource is a math/rand source that counts its draws, so that its
+// state can be saved and later restored by replaying them
+type countingSource struct {
+	state rngState
+	src   rand.Source64
+}
+
+func newCountingSource(st rngState) *countingSource {
+	s := &countingSource{
+		state: rngState{Seed: st.Seed},
+		src:   rand.NewSource(st.Seed).(rand.Source64),
+	}
+	for s.state.Count < st.Count {
+		s.Uint64()
+	}
+	return s
+}
+
+func (s *countingSource) Int63() int64 {
+	s.state.Count++
+	return s.src.Int63()
+}
+
+func (s *countingSource) Uint64() uint64 {
+	s.state.Count++
+	return s.src.Uint64()
+}
+
+func (s *countingSource) Seed(seed int64) {
+	s.state = rngState{Seed: seed}
+	s.src.Seed(seed)
+}
+
+// trainState is a genetic run in progress. A nil population starts a new run.
+type trainState struct {
+	Generation int
+	PrevScores [termStride]float64
+	Population []*deep.Neural
+}
+
+// checkpoint is what's written to disk: the run in progress plus the settings
+// needed to carry on with it
+type checkpoint struct {
+	Features    featureSet
+	WalkForward bool
+	Windows     int
+	// Window is the walk-forward window being trained
+	Window int
+	// DataTo is the newest match the run started with. Later matches are left
+	// out on resume so the population is still scored on the same data.
+	DataTo     time.Time
+	RNG        rngState
+	Generation int
+	PrevScores [termStride]float64
+	Population []*deep.Dump
+}
+
+// trainer runs genetic training, checkpointing it every few generations and
+// when interrupted
+type trainer struct {
+	ctx   context.Context
+	path  string
+	every int
+
+	ck  checkpoint
+	st  trainState
+	src *countingSource
+	rng *rand.Rand
+}
+
+func newTrainer(ctx context.Context, path string, every int, ck checkpoint) *trainer {
+	t := &trainer{ctx: ctx, path: path, every: every, ck: ck}
+	t.src = newCountingSource(ck.RNG)
+	t.rng = rand.New(t.src)
+	t.st.Generation = ck.Generation
+	t.st.PrevScores = ck.PrevScores
+	for _, dump := range ck.Population {
+		t.st.Population = append(t.st.Population, deep.FromDump(dump))
+	}
+	return t
+}
+
+func loadCheckpoint(path string) (checkpoint, error) {
+	var ck checkpoint
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ck, err
+	}
+	if err := json.Unmarshal(blob, &ck); err != nil {
+		return ck, err
+	}
+	return ck, ck.Features.Validate()
+}
+
+// run trains one network, picking up the checkpointed population if there is
+// one
+func (t *trainer) run(ncfg *deep.Config, eval evalFunc) (*deep.Neural, float64) {
+	return train(t.ctx, ncfg, eval, t.rng, &t.st, func() {
+		if t.ctx.Err() != nil || t.st.Generation%t.every == 0 {
+			t.save()
+		}
+	})
+}
+
+// done records that the network from the last run has been saved, so a
+// resume starts the next one
+func (t *trainer) done() {
+	t.st = trainState{}
+	t.save()
+}
+
+func (t *trainer) save() {
+	t.ck.RNG = t.src.state
+	t.ck.Generation = t.st.Generation
+	t.ck.PrevScores = t.st.PrevScores
+	t.ck.Population = t.ck.Population[:0]
+	for _, nn := range t.st.Population {
+		t.ck.Population = append(t.ck.Population, nn.Dump())
+	}
+	blob, err := json.Marshal(t.ck)
+	if err != nil {
+		log.Printf("error: checkpoint: %s", err)
+		return
+	}
+	tmp := t.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, blob, 0644); err != nil {
+		log.Printf("error: checkpoint: %s", err)
+		return
+	}
+	if err := os.Rename(tmp, t.path); err != nil {
+		log.Printf("error: checkpoint: %s", err)
+		return
+	}
+	log.Printf("checkpointed generation %d", t.st.Generation)
+}
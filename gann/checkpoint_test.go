@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"time"
+
+	deep "github.com/patrikeh/go-deep"
+)
+
+func TestCountingSourceReplay(t *testing.T) {
+	src := newCountingSource(rngState{Seed: 42})
+	rng := rand.New(src)
+	// mix calls that draw from Int63 and Uint64
+	for i := 0; i < 100; i++ {
+		rng.Float64()
+		rng.Uint64()
+		rng.Intn(1000)
+	}
+	st := src.state
+	var want []int64
+	for i := 0; i < 50; i++ {
+		want = append(want, rng.Int63())
+	}
+	restored := rand.New(newCountingSource(st))
+	for i, w := range want {
+		if got := restored.Int63(); got != w {
+			t.Fatalf("draw %d after restoring = %d, want %d", i, got, w)
+		}
+	}
+}
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "train.ck")
+	ck := checkpoint{
+		Features: legacyFeatures,
+		DataTo:   time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		RNG:      rngState{Seed: 7},
+	}
+	tr := newTrainer(context.Background(), path, 1, ck)
+	tr.st.Generation = 12
+	for i := range tr.st.PrevScores {
+		tr.st.PrevScores[i] = float64(i * 1000)
+	}
+	for i := 0; i < 3; i++ {
+		tr.st.Population = append(tr.st.Population, deep.NewNeural(betConfig(legacyFeatures)))
+	}
+	tr.rng.Int63()
+	tr.save()
+
+	loaded, err := loadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Features.Key() != legacyFeatures.Key() || !loaded.DataTo.Equal(ck.DataTo) || loaded.RNG != tr.src.state {
+		t.Errorf("settings = %v %v %+v", loaded.Features, loaded.DataTo, loaded.RNG)
+	}
+	restored := newTrainer(context.Background(), path, 1, loaded)
+	if restored.st.Generation != 12 || restored.st.PrevScores != tr.st.PrevScores {
+		t.Errorf("generation %d, scores %v", restored.st.Generation, restored.st.PrevScores)
+	}
+	if len(restored.st.Population) != len(tr.st.Population) {
+		t.Fatalf("got %d networks, want %d", len(restored.st.Population), len(tr.st.Population))
+	}
+	in := make([]float64, len(legacyFeatures))
+	for i := range in {
+		in[i] = float64(i) / 10
+	}
+	for i, nn := range tr.st.Population {
+		want, got := nn.Predict(in), restored.st.Population[i].Predict(in)
+		for j := range want {
+			if got[j] != want[j] {
+				t.Errorf("network %d output %d = %v, want %v", i, j, got[j], want[j])
+			}
+		}
+	}
+	if a, b := tr.rng.Int63(), restored.rng.Int63(); a != b {
+		t.Errorf("restored RNG drew %d, want %d", b, a)
+	}
+}
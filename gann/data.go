@@ -1,12 +1,11 @@
 package main
 
 import (
-	"fmt"
 	"math"
 	"sync"
 	"time"
 
-	"github.com/jackc/pgx"
+	"github.com/spf13/viper"
 )
 
 const potAvgDecay = 0.2
@@ -14,15 +13,24 @@ const potAvgDecay = 0.2
 // records
 
 type matchRecord struct {
+	ID       string
+	TS       time.Time
 	Tier     string
+	Mode     string
 	Name     [2]string
 	Pot      [2]int64
 	PotAvg   float64
 	Winner   int
 	Duration int
 
-	bvo sync.Once
-	bvc []float64
+	bvMu sync.Mutex
+	bvc  map[bvKey][]float64
+}
+
+// bvKey is the stats and feature set a cached bet vector was computed from
+type bvKey struct {
+	d        *tierData
+	features string
 }
 
 func newRecord(tier, winner, loser string, winpot, losepot int64, potAvg float64, duration int) *matchRecord {
@@ -42,11 +50,11 @@ func newRecord(tier, winner, loser string, winpot, losepot int64, potAvg float64
 	return r
 }
 
-func newLiveRecord(tier, a, b string, potAvg float64) *matchRecord {
+func newLiveRecord(tier, mode, a, b string, potAvg float64) *matchRecord {
 	if a > b {
 		a, b = b, a
 	}
-	return &matchRecord{Tier: tier, Name: [2]string{a, b}}
+	return &matchRecord{Tier: tier, Mode: mode, Name: [2]string{a, b}, PotAvg: potAvg}
 }
 
 func (r *matchRecord) Names() (string, string) {
@@ -54,64 +62,57 @@ func (r *matchRecord) Names() (string, string) {
 }
 
 func (r *matchRecord) Payoff(wager float64) float64 {
-	winPot := float64(r.Pot[r.Winner])
-	losePot := float64(r.Pot[1-r.Winner])
+	return payoff(wager, float64(r.Pot[r.Winner]), float64(r.Pot[1-r.Winner]))
+}
+
+// payoff is what a winning wager on the side with winPot collects from
+// losePot. The wager is part of its own side's pot.
+func payoff(wager, winPot, losePot float64) float64 {
 	return wager * losePot / (wager + winPot)
 }
 
 func getRecords(table string, since time.Time, initPotAvg float64, tournament bool) (tierRecs map[string][]*matchRecord, ts time.Time, potAvg float64, err error) {
 	potAvg = initPotAvg
 	tierRecs = make(map[string][]*matchRecord)
-	cfg, err := pgx.ParseEnvLibpq()
-	if err != nil {
-		return
-	}
-	conn, err := pgx.Connect(cfg)
-	if err != nil {
-		return
-	}
-	defer conn.Close()
-	modes := "'matchmaking'"
+	modes := []string{"matchmaking"}
 	if tournament {
-		modes += ", 'tournament'"
+		modes = append(modes, "tournament")
 	}
-	q := fmt.Sprintf("SELECT ts, tier, winner, loser, winpot, losepot, duration FROM %s WHERE mode IN (%s) AND ts > $1 ORDER BY ts", table, modes)
-	rows, err := conn.Query(q, since)
+	rows, err := db.PaidMatches(table, since, modes)
 	if err != nil {
 		return
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var tier, winner, loser string
-		var winpot, losepot int64
-		var duration int
-		var recTS time.Time
-		if err = rows.Scan(&recTS, &tier, &winner, &loser, &winpot, &losepot, &duration); err != nil {
-			return
-		}
-		if winpot == 0 || losepot == 0 || duration == 0 {
+	for _, row := range rows {
+		if row.WinPot == 0 || row.LosePot == 0 || row.Duration == 0 {
 			continue
 		}
-		if _, ok := tierIdx[tier]; !ok {
+		if _, ok := tierIdx[row.Tier]; !ok {
 			continue
 		}
-		totPot := float64(winpot + losepot)
+		totPot := float64(row.WinPot + row.LosePot)
 		if potAvg == 0 {
 			potAvg = totPot
 		} else {
 			potAvg = potAvgDecay*totPot + (1-potAvgDecay)*potAvg
 		}
-		tierRecs[tier] = append(tierRecs[tier], newRecord(tier, winner, loser, winpot, losepot, potAvg, duration))
-		if recTS.After(ts) {
-			ts = recTS
+		rec := newRecord(row.Tier, row.Winner, row.Loser, row.WinPot, row.LosePot, potAvg, row.Duration)
+		rec.ID, rec.TS, rec.Mode = row.ID, row.TS, row.Mode
+		tierRecs[row.Tier] = append(tierRecs[row.Tier], rec)
+		if row.TS.After(ts) {
+			ts = row.TS
 		}
 	}
-	if err = rows.Err(); err != nil {
-		return
-	}
 	return
 }
 
+// watchChannel returns the chat channel whose matches are used
+func watchChannel() string {
+	if ch := viper.GetString("channel"); ch != "" {
+		return ch
+	}
+	return "#saltybet"
+}
+
 // stats
 
 type charStats struct {
@@ -119,6 +120,7 @@ type charStats struct {
 	Wins, Losses      float64
 	WinTime, LoseTime float64
 	Favor             float64
+	Rating            glicko
 
 	matchups map[string]matchup
 }
@@ -152,12 +154,15 @@ func (m charStatsMap) Update(recs []*matchRecord) {
 		iwin, ilose := rec.Winner, 1-rec.Winner
 		swin := m[rec.Name[iwin]]
 		if swin == nil {
-			swin = &charStats{Name: rec.Name[iwin], Favor: 1}
+			swin = &charStats{Name: rec.Name[iwin], Favor: 1, Rating: newGlicko()}
 		}
 		slose := m[rec.Name[ilose]]
 		if slose == nil {
-			slose = &charStats{Name: rec.Name[ilose], Favor: 1}
+			slose = &charStats{Name: rec.Name[ilose], Favor: 1, Rating: newGlicko()}
 		}
+		rwin, rlose := swin.Rating, slose.Rating
+		swin.Rating = rwin.Update(rlose, 1)
+		slose.Rating = rlose.Update(rwin, 0)
 		swin.Wins++
 		swin.WinTime += float64(rec.Duration)
 		swin.AddMatchup(rec.Name[ilose], true)
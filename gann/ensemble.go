@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/spf13/viper"
+)
+
+// ensemble combines the wagers of several networks
+type ensemble struct {
+	Members []predictor
+	// Mode is "mean", "vote" or "weighted"
+	Mode string
+	// MaxDisagree is the largest share of members that may pick the other
+	// side before the match is skipped
+	MaxDisagree float64
+}
+
+// loadEnsemble loads the top scoring networks by rank_fitness from a registry
+// directory, with settings from ensemble_size, ensemble_mode and
+// ensemble_max_disagree
+func loadEnsemble(dir string) (*ensemble, error) {
+	size := 5
+	if viper.IsSet("ensemble_size") {
+		size = viper.GetInt("ensemble_size")
+	}
+	e := &ensemble{Mode: viper.GetString("ensemble_mode"), MaxDisagree: 0.25}
+	if viper.IsSet("ensemble_max_disagree") {
+		e.MaxDisagree = viper.GetFloat64("ensemble_max_disagree")
+	}
+	switch e.Mode {
+	case "":
+		e.Mode = "mean"
+	case "mean", "vote", "weighted":
+	default:
+		return nil, fmt.Errorf("unknown ensemble_mode %q", e.Mode)
+	}
+	if size < 1 {
+		return nil, errors.New("ensemble_size must be at least 1")
+	}
+	reg, err := loadRegistry(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range reg.Ranked(rankFitness()) {
+		if len(e.Members) == size {
+			break
+		}
+		nn, err := reg.Load(entry)
+		if err != nil {
+			return nil, err
+		}
+		e.Members = append(e.Members, nn)
+	}
+	if len(e.Members) == 0 {
+		return nil, fmt.Errorf("no models scored by %s in %s", rankFitness(), dir)
+	}
+	return e, nil
+}
+
+// Wager returns the combined wager, or false if the members disagree too much
+// to bet. A member that wagers exactly 0 abstains. The vector holds each
+// member's wager.
+func (e *ensemble) Wager(d *tierData, rec *matchRecord, bank float64) (wager, []float64, bool) {
+	outs := make([]float64, 0, len(e.Members))
+	var forA, forB float64
+	for _, nn := range e.Members {
+		wg, _, ok := nn.Wager(d, rec, bank)
+		if !ok {
+			return 0, nil, false
+		}
+		outs = append(outs, float64(wg))
+		switch {
+		case wg > 0:
+			forB++
+		case wg < 0:
+			forA++
+		}
+	}
+	n := forA + forB
+	if n == 0 || math.Min(forA, forB)/n > e.MaxDisagree {
+		return 0, outs, false
+	}
+	var combined float64
+	switch e.Mode {
+	case "mean":
+		for _, o := range outs {
+			combined += o
+		}
+		combined /= n
+	case "vote":
+		// the majority's average size
+		if forA == forB {
+			return 0, outs, false
+		}
+		var sum, count float64
+		for _, o := range outs {
+			if o != 0 && (o > 0) == (forB > forA) {
+				sum += math.Abs(o)
+				count++
+			}
+		}
+		combined = sum / count
+		if forB < forA {
+			combined = -combined
+		}
+	case "weighted":
+		// members count in proportion to their own confidence
+		var sum, weight float64
+		for _, o := range outs {
+			sum += o * math.Abs(o)
+			weight += math.Abs(o)
+		}
+		if weight > 0 {
+			combined = sum / weight
+		}
+	}
+	return wager(combined), outs, true
+}
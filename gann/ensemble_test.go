@@ -0,0 +1,46 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// fixedWager always predicts the same wager
+type fixedWager wager
+
+func (w fixedWager) Wager(d *tierData, rec *matchRecord, bank float64) (wager, []float64, bool) {
+	return wager(w), nil, true
+}
+
+func TestEnsembleWager(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		mode string
+		outs []float64
+		want float64
+		ok   bool
+	}{
+		{"mean agrees", "mean", []float64{0.2, 0.4, 0.6}, 0.4, true},
+		{"mean too split", "mean", []float64{0.2, -0.4, 0.6}, 0, false},
+		{"mean ignores abstention", "mean", []float64{0.2, 0, 0.4}, 0.3, true},
+		{"everyone abstains", "mean", []float64{0, 0, 0}, 0, false},
+		{"vote majority size", "vote", []float64{-0.2, -0.4, -0.6, -0.1, 0.3}, -0.325, true},
+		{"vote tied", "vote", []float64{0.2, -0.2}, 0, false},
+		{"vote abstention isn't for a", "vote", []float64{0.2, 0, 0}, 0.2, true},
+		{"weighted", "weighted", []float64{0.5, 0.1, 0.1, 0.1}, (0.25 + 3*0.01) / 0.8, true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			e := &ensemble{Mode: c.mode, MaxDisagree: 0.25}
+			for _, o := range c.outs {
+				e.Members = append(e.Members, fixedWager(o))
+			}
+			got, outs, ok := e.Wager(nil, nil, whaleStart)
+			if ok != c.ok || math.Abs(float64(got)-c.want) > 1e-9 {
+				t.Errorf("Wager = %v, %v, want %v, %v", got, ok, c.want, c.ok)
+			}
+			if ok && len(outs) != len(c.outs) {
+				t.Errorf("got %d member outputs, want %d", len(outs), len(c.outs))
+			}
+		})
+	}
+}
@@ -2,8 +2,7 @@ package main
 
 import (
 	"context"
-	"fmt"
-	"io/ioutil"
+	"flag"
 	"log"
 	"math/rand"
 	"os"
@@ -27,6 +26,16 @@ type tierData struct {
 
 	weights [][][]float64
 	ppool   sync.Pool
+
+	// tmu guards the caches below, which are computed without holding it and
+	// only kept if version shows no results were added meanwhile
+	tmu     sync.Mutex
+	version int
+	trans   map[[2]string]transitive
+	btFit   map[string]btStrength
+	// btEvery is how many results can be added before the fit is redone, so
+	// long replays needn't refit after every match. Zero refits every time.
+	btEvery, btStale int
 }
 
 var (
@@ -40,51 +49,166 @@ var (
 	tiers [5]*tierData
 )
 
+// tierOrder returns tier names from lowest to highest
+func tierOrder() []string {
+	names := make([]string, len(tierIdx))
+	for name, i := range tierIdx {
+		names[i] = name
+	}
+	return names
+}
+
 func main() {
 	viper.AutomaticEnv()
 	if len(os.Args) < 2 {
-		log.Fatalln("pred, train, bet")
+		log.Fatalln("migrate, pred, train, bet, backtest, explain, bt, models")
+	}
+	if err := openStore(); err != nil {
+		log.Fatalln("error:", err)
+	}
+	if os.Args[1] == "migrate" {
+		if err := db.Migrate(); err != nil {
+			log.Fatalln("error:", err)
+		}
+		return
+	}
+	if err := db.CheckSchema(); err != nil {
+		log.Fatalln("error:", err)
 	}
 	switch os.Args[1] {
 	case "pred":
-		prepData(true)
+		prepData(true, time.Time{})
+	case "backtest":
+		backtestCmd(os.Args[2:])
+	case "explain":
+		explain(os.Args[2:])
+	case "bt":
+		printBT(os.Args[2:])
+	case "models":
+		modelsCmd(os.Args[2:])
 	case "train":
-		workDir := "_bnet"
-		if err := os.MkdirAll(workDir, 0755); err != nil {
+		trainCmd(os.Args[2:])
+	case "bet":
+		pred, err := loadPredictor(viper.GetString("predictor"), "_bnet")
+		if err != nil {
 			log.Fatalln("error:", err)
 		}
-		ctx, cancel := context.WithCancel(context.Background())
-		go func() {
-			tsig := make(chan os.Signal)
-			signal.Notify(tsig, syscall.SIGINT)
-			<-tsig
-			signal.Stop(tsig)
-			cancel()
-		}()
-		rng := rand.New(rand.NewSource(42))
-		allRecs, _, _ := prepData(true)
-		recSets := sliceRecs(allRecs)
-		for ctx.Err() == nil {
-			evalFunc := func(nn *deep.Neural) float64 {
-				scores := make(sort.Float64Slice, len(recSets))
-				for i, recSet := range recSets {
-					scores[i] = simulateWhale(nn, recSet, false, false)
-				}
-				sort.Sort(scores)
-				return scores[len(scores)/2]
+		sz, err := loadSizer(pred, "_bnet", allRecords)
+		if err != nil {
+			log.Fatalln("error:", err)
+		}
+		var src matchSource
+		switch viper.GetString("match_source") {
+		case "postgres":
+			if _, ok := db.(pgStore); !ok {
+				log.Fatalln("error: match_source=postgres requires a Postgres database")
+			}
+			src, err = newPGSource()
+			if err != nil {
+				log.Fatalln("error:", err)
 			}
-			nn, score := train(ctx, betCfg, evalFunc, rng)
-			blob, _ := nn.Marshal()
-			ioutil.WriteFile(filepath.Join(workDir, fmt.Sprintf("%d.%d.dat", int64(score), time.Now().Unix())), blob, 0644)
+		case "", "http":
+			src = httpSource{URL: viper.GetString("metadata")}
+		default:
+			log.Fatalln("error: unknown match_source", viper.GetString("match_source"))
 		}
-	case "bet":
-		nn, err := netFromFiles("_bnet")
+		go http.ListenAndServe(":6666", nil)
+		watchAndRun(pred, sz, src)
+	}
+}
+
+func trainCmd(args []string) {
+	workDir := "_bnet"
+	flags := flag.NewFlagSet("train", flag.ExitOnError)
+	walkForward := flags.Bool("walkforward", false, "train and score on consecutive windows of matches in the order they were played")
+	windows := flags.Int("windows", 6, "number of walk-forward windows")
+	resume := flags.Bool("resume", false, "continue from the checkpoint instead of starting over")
+	ckPath := flags.String("checkpoint", filepath.Join(workDir, "checkpoint.json"), "checkpoint file")
+	every := flags.Int("checkpoint-every", 5, "generations between checkpoints")
+	force := flags.Bool("force", false, "start over even if there is a checkpoint")
+	flags.Parse(args)
+
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		log.Fatalln("error:", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		tsig := make(chan os.Signal, 1)
+		signal.Notify(tsig, syscall.SIGINT, syscall.SIGTERM)
+		<-tsig
+		signal.Stop(tsig)
+		cancel()
+	}()
+	var ck checkpoint
+	if *resume {
+		flags.Visit(func(f *flag.Flag) {
+			if f.Name == "walkforward" || f.Name == "windows" {
+				log.Fatalf("error: -%s can't be changed when resuming", f.Name)
+			}
+		})
+		var err error
+		ck, err = loadCheckpoint(*ckPath)
+		if err != nil {
+			log.Fatalln("error: loading checkpoint:", err)
+		}
+		log.Printf("resuming at generation %d", ck.Generation)
+	} else {
+		if _, err := os.Stat(*ckPath); err == nil && !*force {
+			log.Fatalf("error: %s exists, use -resume to continue it or -force to start over", *ckPath)
+		}
+		fs, err := trainFeatures()
 		if err != nil {
 			log.Fatalln("error:", err)
 		}
-		go http.ListenAndServe(":6666", nil)
-		metaURL := viper.GetString("metadata")
-		watchAndRun(nn, metaURL)
+		ck = checkpoint{Features: fs, WalkForward: *walkForward, Windows: *windows, RNG: rngState{Seed: 42}}
+	}
+	fs := ck.Features
+	log.Printf("training with features %s", fs.Key())
+	t := newTrainer(ctx, *ckPath, *every, ck)
+	reg, err := loadRegistry(workDir)
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+	save := func(nn *deep.Neural, e *modelEntry) {
+		if ctx.Err() != nil {
+			// the run is checkpointed and its best is saved when it finishes
+			log.Printf("interrupted, not saving the unfinished network")
+			return
+		}
+		if err := reg.Add(betNet{nn, fs}, e); err != nil {
+			log.Printf("error: saving model: %s", err)
+		} else {
+			log.Printf("saved %s", e.File)
+		}
+	}
+	if ck.WalkForward {
+		trainWalkForward(t, save)
+		return
+	}
+	allRecs, ts, _ := prepData(true, t.ck.DataTo)
+	t.ck.DataTo = ts
+	recSets := sliceRecs(allRecs)
+	for ctx.Err() == nil {
+		nn, score := t.run(betConfig(fs), medianWhale(fs, tiers, recSets))
+		e := &modelEntry{Fitness: "median-whale", Score: score}
+		e.recordRange(allRecs)
+		save(nn, e)
+		if ctx.Err() == nil {
+			t.done()
+		}
+	}
+}
+
+// medianWhale scores a network by its median result over several sets of
+// matches, predicting from the stats in ts
+func medianWhale(fs featureSet, ts [5]*tierData, recSets [][]*matchRecord) evalFunc {
+	return func(nn *deep.Neural) float64 {
+		scores := make(sort.Float64Slice, len(recSets))
+		for i, recSet := range recSets {
+			scores[i] = simulateWhale(betNet{nn, fs}, ts, recSet, false, false)
+		}
+		sort.Sort(scores)
+		return scores[len(scores)/2]
 	}
 }
 
@@ -98,15 +222,19 @@ func sliceRecs(recs []*matchRecord) [][]*matchRecord {
 	return recSets
 }
 
-func prepData(split bool) (allRecs []*matchRecord, ts time.Time, avgPot float64) {
+// prepData loads the stats for every tier, holding out half the matches for
+// training if split is set. Matches after until are ignored unless it's zero.
+func prepData(split bool, until time.Time) (allRecs []*matchRecord, ts time.Time, avgPot float64) {
 	rng := rand.New(rand.NewSource(123))
 	tierRecs, ts, avgPot, err := getRecords("all_matches", time.Time{}, 0, false)
 	if err != nil {
 		log.Fatalln("error:", err)
 	}
-	for tier, i := range tierIdx {
-		recs := tierRecs[tier]
-		chars := make(charStatsMap)
+	if !until.IsZero() && ts.After(until) {
+		ts = until
+	}
+	for i, tier := range tierOrder() {
+		recs := recordsUntil(tierRecs[tier], until)
 		var toTrain, forStats []*matchRecord
 		if split {
 			for _, rec := range recs {
@@ -119,9 +247,10 @@ func prepData(split bool) (allRecs []*matchRecord, ts time.Time, avgPot float64)
 		} else {
 			forStats = recs
 		}
-		chars.Update(forStats)
+		d := &tierData{recs: toTrain, chars: make(charStatsMap)}
+		d.Update(forStats)
 		allRecs = append(allRecs, toTrain...)
-		tiers[i] = &tierData{recs: toTrain, chars: chars}
+		tiers[i] = d
 	}
 	return
 }
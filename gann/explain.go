@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// explain prints what gann knows about a matchup: each character's record
+// and rating, and every registered feature
+func explain(args []string) {
+	if len(args) < 2 {
+		log.Fatalln("usage: explain <name1> <name2> [tier]")
+	}
+	name1, name2 := args[0], args[1]
+	prepData(false, time.Time{})
+	var tierNames []string
+	if len(args) > 2 {
+		if _, ok := tierIdx[args[2]]; !ok {
+			log.Fatalln("error: unknown tier", args[2])
+		}
+		tierNames = []string{args[2]}
+	} else {
+		tierNames = tierOrder()
+	}
+	nn, err := promotedNet("_bnet")
+	if err != nil {
+		log.Printf("warning: no network to predict with: %s", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	var found bool
+	for _, tier := range tierNames {
+		d := tiers[tierIdx[tier]]
+		a, b := d.chars[name1], d.chars[name2]
+		if a == nil || b == nil {
+			continue
+		}
+		found = true
+		fmt.Fprintf(w, "TIER %s\tW-L\tWIN RATE\tRATING\tDEVIATION\tVOLATILITY\n", tier)
+		for _, s := range []*charStats{a, b} {
+			fmt.Fprintf(w, "%s\t%.0f-%.0f\t%.3f\t%.0f\t%.0f\t%.4f\n", s.Name, s.Wins, s.Losses, s.WinRate(), s.Rating.Rating, s.Rating.Deviation, s.Rating.Volatility)
+		}
+		fmt.Fprintf(w, "%s beats %s\t%.3f\n", name1, name2, a.Rating.Expect(b.Rating))
+		direct := d.chars.Direct(name1, name2)
+		fmt.Fprintf(w, "head to head\t%.0f-%.0f\n", direct.Wins, direct.Losses)
+		fit := d.bt()
+		fmt.Fprintf(w, "%s beats %s (Bradley-Terry)\t%.3f\n", name1, name2, btProb(fit[name1], fit[name2]))
+		// features are always from the perspective of the first name in sort order
+		rec := newLiveRecord(tier, "matchmaking", name1, name2, 0)
+		fmt.Fprintf(w, "features for %s\n", rec.Name[0])
+		var names featureSet
+		for name := range features {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for i, v := range d.BetVector(names, rec, whaleStart) {
+			fmt.Fprintf(w, "  %s\t%.4f\n", names[i], v)
+		}
+		if nn.Neural != nil {
+			wg, _, _ := nn.Wager(d, rec, whaleStart)
+			pick := rec.Name[0]
+			if wg.PredictB() {
+				pick = rec.Name[1]
+			}
+			fmt.Fprintf(w, "prediction\t%s size=%.4f\n", pick, wg.Size())
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+	if !found {
+		log.Fatalf("error: no tier has data for both %q and %q", name1, name2)
+	}
+}
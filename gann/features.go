@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// feature extracts one input of the bet vector. Features that depend on the
+// bank are recomputed on every prediction and must not look at the stats; the
+// rest are cached per record.
+type feature struct {
+	Extract  func(d *tierData, rec *matchRecord, bank float64) float64
+	UsesBank bool
+}
+
+// directWeight is the number of head-to-head meetings at which the direct
+// record and the transitive score count equally toward "matchup"
+const directWeight = 2
+
+var features = map[string]feature{
+	"winrate": {Extract: func(d *tierData, rec *matchRecord, bank float64) float64 {
+		a, b := d.pair(rec)
+		return a.WinRate() - b.WinRate()
+	}},
+	"favor": {Extract: func(d *tierData, rec *matchRecord, bank float64) float64 {
+		a, b := d.pair(rec)
+		return a.CrowdFavor() - b.CrowdFavor()
+	}},
+	"wintime": {Extract: func(d *tierData, rec *matchRecord, bank float64) float64 {
+		a, b := d.pair(rec)
+		return a.AvgWinTime() - b.AvgWinTime()
+	}},
+	"leastgames": {Extract: func(d *tierData, rec *matchRecord, bank float64) float64 {
+		return leastGames(d.pair(rec))
+	}},
+	"tier": {Extract: func(d *tierData, rec *matchRecord, bank float64) float64 {
+		return float64(tierIdx[rec.Tier])
+	}},
+	"graph3": {Extract: func(d *tierData, rec *matchRecord, bank float64) float64 {
+		return d.transitive(rec.Name[0], rec.Name[1]).Graph3
+	}},
+	"abxy": {Extract: func(d *tierData, rec *matchRecord, bank float64) float64 {
+		return d.transitive(rec.Name[0], rec.Name[1]).ABXY
+	}},
+	"matchup": {Extract: func(d *tierData, rec *matchRecord, bank float64) float64 {
+		// lean on the transitive score until the pair has met a few times
+		direct := d.chars.Direct(rec.Name[0], rec.Name[1])
+		n := direct.Wins + direct.Losses
+		w := n / (n + directWeight)
+		return w*direct.Score() + (1-w)*d.transitive(rec.Name[0], rec.Name[1]).Graph3
+	}},
+	"rating": {Extract: func(d *tierData, rec *matchRecord, bank float64) float64 {
+		a, b := d.pair(rec)
+		return a.Rating.mu() - b.Rating.mu()
+	}},
+	"ratingprob": {Extract: func(d *tierData, rec *matchRecord, bank float64) float64 {
+		a, b := d.pair(rec)
+		return a.Rating.Expect(b.Rating) - 0.5
+	}},
+	"deviation": {Extract: func(d *tierData, rec *matchRecord, bank float64) float64 {
+		a, b := d.pair(rec)
+		return math.Max(a.Rating.phi(), b.Rating.phi())
+	}},
+	"bt": {Extract: func(d *tierData, rec *matchRecord, bank float64) float64 {
+		return d.btProb(rec) - 0.5
+	}},
+	"bank": {UsesBank: true, Extract: func(d *tierData, rec *matchRecord, bank float64) float64 {
+		return math.Log10(math.Max(bank, 1))
+	}},
+}
+
+// legacyFeatures is the vector that networks saved without a feature list
+// were trained with
+var legacyFeatures = featureSet{"winrate", "favor", "wintime", "leastgames", "tier"}
+
+type featureSet []string
+
+func parseFeatures(s string) (featureSet, error) {
+	var fs featureSet
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			fs = append(fs, name)
+		}
+	}
+	if len(fs) == 0 {
+		return nil, fmt.Errorf("empty feature list %q", s)
+	}
+	return fs, fs.Validate()
+}
+
+// trainFeatures returns the feature list new networks are trained with
+func trainFeatures() (featureSet, error) {
+	if s := viper.GetString("features"); s != "" {
+		return parseFeatures(s)
+	}
+	return legacyFeatures, nil
+}
+
+func (fs featureSet) Validate() error {
+	seen := make(map[string]bool)
+	for _, name := range fs {
+		if _, ok := features[name]; !ok {
+			return fmt.Errorf("unknown feature %q", name)
+		} else if seen[name] {
+			return fmt.Errorf("duplicate feature %q", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+func (fs featureSet) Key() string {
+	return strings.Join(fs, ",")
+}
+
+func (fs featureSet) usesBank() bool {
+	for _, name := range fs {
+		if features[name].UsesBank {
+			return true
+		}
+	}
+	return false
+}
+
+// BetVector assembles the inputs named by fs, or returns nil if either
+// character has no stats in this tier. The stats-based part of the vector is
+// cached on the record for each tierData, as it was the first time d computed
+// it.
+func (d *tierData) BetVector(fs featureSet, rec *matchRecord, bank float64) []float64 {
+	key := bvKey{d, fs.Key()}
+	rec.bvMu.Lock()
+	v, ok := rec.bvc[key]
+	if !ok {
+		v = d.betVector(fs, rec, bank)
+		if rec.bvc == nil {
+			rec.bvc = make(map[bvKey][]float64)
+		}
+		rec.bvc[key] = v
+	}
+	rec.bvMu.Unlock()
+	if v == nil || !fs.usesBank() {
+		return v
+	}
+	v = append([]float64(nil), v...)
+	for i, name := range fs {
+		if f := features[name]; f.UsesBank {
+			v[i] = f.Extract(d, rec, bank)
+		}
+	}
+	return v
+}
+
+func (d *tierData) betVector(fs featureSet, rec *matchRecord, bank float64) []float64 {
+	if d.chars[rec.Name[0]] == nil || d.chars[rec.Name[1]] == nil {
+		return nil
+	}
+	v := make([]float64, len(fs))
+	for i, name := range fs {
+		v[i] = features[name].Extract(d, rec, bank)
+	}
+	return v
+}
+
+func (d *tierData) pair(rec *matchRecord) (*charStats, *charStats) {
+	return d.chars[rec.Name[0]], d.chars[rec.Name[1]]
+}
+
+func leastGames(astat, bstat *charStats) float64 {
+	agames := astat.Wins + astat.Losses
+	bgames := bstat.Wins + bstat.Losses
+	if bgames < agames {
+		return bgames
+	}
+	return agames
+}
@@ -0,0 +1,87 @@
+package main
+
+import "math"
+
+// Glicko-2 with every match treated as its own rating period, see
+// http://www.glicko.net/glicko/glicko2.pdf
+const (
+	glickoScale       = 173.7178
+	glickoTau         = 0.5
+	glickoEpsilon     = 1e-6
+	defaultRating     = 1500
+	defaultDeviation  = 350
+	defaultVolatility = 0.06
+)
+
+type glicko struct {
+	Rating, Deviation, Volatility float64
+}
+
+func newGlicko() glicko {
+	return glicko{defaultRating, defaultDeviation, defaultVolatility}
+}
+
+func (r glicko) mu() float64  { return (r.Rating - defaultRating) / glickoScale }
+func (r glicko) phi() float64 { return r.Deviation / glickoScale }
+
+func glickoG(phi float64) float64 {
+	return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+}
+
+// Expect returns the probability that r beats o, accounting for the
+// uncertainty in both ratings
+func (r glicko) Expect(o glicko) float64 {
+	phi := math.Hypot(r.phi(), o.phi())
+	return 1 / (1 + math.Exp(-glickoG(phi)*(r.mu()-o.mu())))
+}
+
+// Update returns the rating after a single game against o, where score is 1
+// for a win and 0 for a loss
+func (r glicko) Update(o glicko, score float64) glicko {
+	mu, phi, sigma := r.mu(), r.phi(), r.Volatility
+	g := glickoG(o.phi())
+	e := 1 / (1 + math.Exp(-g*(mu-o.mu())))
+	v := 1 / (g * g * e * (1 - e))
+	delta := v * g * (score - e)
+	sigma = glickoVolatility(delta, phi, v, sigma)
+	phiStar := math.Sqrt(phi*phi + sigma*sigma)
+	phi = 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	mu += phi * phi * g * (score - e)
+	return glicko{
+		Rating:     mu*glickoScale + defaultRating,
+		Deviation:  phi * glickoScale,
+		Volatility: sigma,
+	}
+}
+
+func glickoVolatility(delta, phi, v, sigma float64) float64 {
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-phi*phi-v-ex)/(2*d*d) - (x-a)/(glickoTau*glickoTau)
+	}
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*glickoTau) < 0 {
+			k++
+		}
+		B = a - k*glickoTau
+	}
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > glickoEpsilon {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB < 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+	return math.Exp(A / 2)
+}
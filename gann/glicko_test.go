@@ -0,0 +1,36 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGlickoUpdate(t *testing.T) {
+	for _, c := range []struct {
+		name         string
+		r, o         glicko
+		score        float64
+		rating, dev  float64
+		expectBefore float64
+	}{
+		{"new beats new", newGlicko(), newGlicko(), 1, 1662.31, 290.32, 0.5},
+		{"new loses to new", newGlicko(), newGlicko(), 0, 1337.69, 290.32, 0.5},
+		{"favorite wins", glicko{1700, 50, 0.06}, glicko{1300, 50, 0.06}, 1, 1701.37, 50.89, 0.904},
+		{"favorite loses", glicko{1700, 50, 0.06}, glicko{1300, 50, 0.06}, 0, 1686.65, 50.89, 0.904},
+		// the first game of the example in Glickman's paper on its own
+		{"paper example", glicko{1500, 200, 0.06}, glicko{1400, 30, 0.06}, 1, 1563.56, 175.40, 0.619},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if e := c.r.Expect(c.o); math.Abs(e-c.expectBefore) > 0.001 {
+				t.Errorf("Expect = %.4f, want %.3f", e, c.expectBefore)
+			}
+			got := c.r.Update(c.o, c.score)
+			if math.Abs(got.Rating-c.rating) > 0.01 || math.Abs(got.Deviation-c.dev) > 0.01 {
+				t.Errorf("Update = %.2f/%.2f, want %.2f/%.2f", got.Rating, got.Deviation, c.rating, c.dev)
+			}
+			if math.Abs(got.Volatility-c.r.Volatility) > 0.001 {
+				t.Errorf("volatility moved from %.4f to %.4f after one game", c.r.Volatility, got.Volatility)
+			}
+		})
+	}
+}
@@ -27,34 +27,73 @@ func (s *charStats) AddMatchup(opponent string, win bool) {
 	s.matchups[opponent] = s.matchups[opponent].Add(win)
 }
 
-func (cm charStatsMap) Graph3(a, x string) float64 {
-	var sum float64
+type transitive struct {
+	Graph3, ABXY float64
+	Paths        int
+}
+
+// Transitive scores a against x over every three-hop path a-y-b-x, averaged
+// over the number of paths so that well-connected characters don't dominate
+func (cm charStatsMap) Transitive(a, x string) (t transitive) {
 	for y, ym := range cm[a].matchups {
+		ys := ym.Score()
 		for b, bm := range cm[y].matchups {
 			if b == a {
 				continue
 			}
 			xm, ok := cm[b].matchups[x]
 			if ok {
-				sum += ym.Score() + bm.Score() + xm.Score()
+				bs, xs := bm.Score(), xm.Score()
+				t.Graph3 += ys + bs + xs
+				t.ABXY += ys - bs + xs
+				t.Paths++
 			}
 		}
 	}
-	return sum
+	if t.Paths > 0 {
+		t.Graph3 /= 3 * float64(t.Paths)
+		t.ABXY /= 3 * float64(t.Paths)
+	}
+	return
 }
 
-func (cm charStatsMap) ABXY(a, x string) float64 {
-	var sum float64
-	for y, ym := range cm[a].matchups {
-		for b, bm := range cm[y].matchups {
-			if b == a {
-				continue
-			}
-			xm, ok := cm[b].matchups[x]
-			if ok {
-				sum += ym.Score() - bm.Score() + xm.Score()
-			}
+// Direct returns a's head-to-head record against x
+func (cm charStatsMap) Direct(a, x string) matchup {
+	return cm[a].matchups[x]
+}
+
+// transitive returns the cached transitive scores for a pair of characters
+func (d *tierData) transitive(a, x string) transitive {
+	key := [2]string{a, x}
+	d.tmu.Lock()
+	t, ok := d.trans[key]
+	version := d.version
+	d.tmu.Unlock()
+	if ok {
+		return t
+	}
+	t = d.chars.Transitive(a, x)
+	d.tmu.Lock()
+	defer d.tmu.Unlock()
+	if d.version == version {
+		if d.trans == nil {
+			d.trans = make(map[[2]string]transitive)
 		}
+		d.trans[key] = t
+	}
+	return t
+}
+
+// Update adds match results to the tier's stats and drops cached scores
+func (d *tierData) Update(recs []*matchRecord) {
+	d.tmu.Lock()
+	defer d.tmu.Unlock()
+	d.chars.Update(recs)
+	d.version++
+	d.trans = nil
+	d.btStale += len(recs)
+	if d.btStale >= d.btEvery {
+		d.btFit = nil
+		d.btStale = 0
 	}
-	return sum
 }
@@ -27,12 +27,12 @@ const (
 	tournBailout   = 1000 + defaultBailout
 )
 
-var (
-	betCfg = &deep.Config{
-		Inputs:     betVectorSize,
+func betConfig(fs featureSet) *deep.Config {
+	return &deep.Config{
+		Inputs:     len(fs),
 		Layout:     []int{5, 3, betResponseSize},
 		Activation: deep.ActivationSigmoid,
 		Mode:       deep.ModeRegression,
 		Weight:     deep.NewNormal(1.0, 0.0),
 	}
-)
+}
@@ -1,45 +1,75 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
-	"os"
 	"path/filepath"
-	"sort"
-	"strconv"
-	"strings"
 
 	deep "github.com/patrikeh/go-deep"
 )
 
-func netFromFiles(dirname string) (*deep.Neural, error) {
-	f, err := os.Open(dirname)
-	if err != nil {
-		return nil, err
+// betNet is a network together with the features its inputs were trained on
+type betNet struct {
+	*deep.Neural
+	Features featureSet
+}
+
+type savedNet struct {
+	deep.Dump
+	Features featureSet `json:",omitempty"`
+}
+
+func (n betNet) Marshal() ([]byte, error) {
+	return json.Marshal(savedNet{Dump: *n.Dump(), Features: n.Features})
+}
+
+func unmarshalNet(blob []byte) (betNet, error) {
+	var saved savedNet
+	if err := json.Unmarshal(blob, &saved); err != nil {
+		return betNet{}, err
+	} else if saved.Config == nil {
+		return betNet{}, errors.New("missing network config")
 	}
-	names, err := f.Readdirnames(-1)
-	if err != nil {
-		return nil, err
+	if saved.Features == nil {
+		saved.Features = legacyFeatures
 	}
-	var scores []fileScore
-	for _, name := range names {
-		score, _ := strconv.ParseInt(strings.Split(name, ".")[0], 10, 64)
-		scores = append(scores, fileScore{name, float64(score)})
+	if err := saved.Features.Validate(); err != nil {
+		return betNet{}, err
+	} else if saved.Config.Inputs != len(saved.Features) {
+		return betNet{}, fmt.Errorf("network has %d inputs but %d features", saved.Config.Inputs, len(saved.Features))
 	}
-	sort.Slice(scores, func(i, j int) bool { return scores[i].score > scores[j].score })
-	if len(scores) == 0 {
-		return nil, errors.New("no files in " + dirname)
+	return betNet{deep.FromDump(&saved.Dump), saved.Features}, nil
+}
+
+// Wager predicts a bet for rec, or returns false if there is no data for it
+func (n betNet) Wager(d *tierData, rec *matchRecord, bank float64) (wager, []float64, bool) {
+	v := d.BetVector(n.Features, rec, bank)
+	if v == nil {
+		return 0, nil, false
 	}
-	blob, err := ioutil.ReadFile(filepath.Join(dirname, scores[0].name))
+	return wagerFromVector(n.Predict(v)), v, true
+}
+
+func netFromFile(path string) (betNet, error) {
+	nn, err := readNet(path)
 	if err != nil {
-		return nil, err
+		return betNet{}, err
 	}
-	defer log.Printf("loaded %s", scores[0].name)
-	return deep.Unmarshal(blob)
+	log.Printf("loaded %s features=%s", filepath.Base(path), nn.Features.Key())
+	return nn, nil
 }
 
-type fileScore struct {
-	name  string
-	score float64
+func readNet(path string) (betNet, error) {
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		return betNet{}, err
+	}
+	nn, err := unmarshalNet(blob)
+	if err != nil {
+		return betNet{}, fmt.Errorf("%s: %s", path, err)
+	}
+	return nn, nil
 }
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const manifestName = "manifest.json"
+
+// modelEntry records how a saved network was produced and how it scored
+type modelEntry struct {
+	File     string
+	Created  time.Time
+	Features featureSet
+	Inputs   int
+	Layout   []int
+	// Fitness names the function Score was computed with
+	Fitness          string
+	Score            float64
+	DataFrom, DataTo time.Time
+	Matches          int
+	Metrics          map[string]float64 `json:",omitempty"`
+	Calibration      *calibration       `json:",omitempty"`
+	Promoted         bool
+}
+
+// registry is the manifest of every network in a directory
+type registry struct {
+	dir    string
+	Models []*modelEntry
+}
+
+// loadRegistry reads the directory's manifest. Networks saved before there was
+// one are added as median-whale with the score from their file name.
+func loadRegistry(dir string) (*registry, error) {
+	reg := &registry{dir: dir}
+	blob, err := ioutil.ReadFile(filepath.Join(dir, manifestName))
+	if err == nil {
+		if err := json.Unmarshal(blob, reg); err != nil {
+			return nil, fmt.Errorf("%s: %s", manifestName, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	names, err := filepath.Glob(filepath.Join(dir, "*.dat"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range names {
+		name := filepath.Base(path)
+		if reg.Get(name) != nil {
+			continue
+		}
+		nn, err := readNet(path)
+		if err != nil {
+			log.Printf("warning: skipping %s", err)
+			continue
+		}
+		e := &modelEntry{
+			File:     name,
+			Features: nn.Features,
+			Inputs:   nn.Config.Inputs,
+			Layout:   nn.Config.Layout,
+			// every network was scored this way before the registry
+			Fitness: "median-whale",
+		}
+		parts := strings.Split(name, ".")
+		e.Score, _ = strconv.ParseFloat(parts[0], 64)
+		if len(parts) > 2 {
+			created, _ := strconv.ParseInt(parts[1], 10, 64)
+			e.Created = time.Unix(created, 0)
+		}
+		reg.Models = append(reg.Models, e)
+	}
+	return reg, nil
+}
+
+func (reg *registry) Save() error {
+	blob, err := json.MarshalIndent(reg, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(reg.dir, manifestName)
+	if err := ioutil.WriteFile(path+".tmp", blob, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// reload rereads the manifest so that a change made by another process since
+// it was loaded isn't overwritten
+func (reg *registry) reload() error {
+	fresh, err := loadRegistry(reg.dir)
+	if err != nil {
+		return err
+	}
+	reg.Models = fresh.Models
+	return nil
+}
+
+func (reg *registry) Get(file string) *modelEntry {
+	for _, e := range reg.Models {
+		if e.File == file {
+			return e
+		}
+	}
+	return nil
+}
+
+// Add writes a network and records it
+func (reg *registry) Add(nn betNet, e *modelEntry) error {
+	blob, err := nn.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := reg.reload(); err != nil {
+		return err
+	}
+	e.Created = time.Now().UTC()
+	e.Features = nn.Features
+	e.Inputs = nn.Config.Inputs
+	e.Layout = nn.Config.Layout
+	f, err := reg.create(e)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(blob)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+	reg.Models = append(reg.Models, e)
+	return reg.Save()
+}
+
+// create picks a file name for a new model that isn't already taken and
+// creates it
+func (reg *registry) create(e *modelEntry) (*os.File, error) {
+	for n := 0; ; n++ {
+		e.File = fmt.Sprintf("%d.%d.dat", int64(e.Score), e.Created.Unix())
+		if n > 0 {
+			e.File = fmt.Sprintf("%d.%d.%d.dat", int64(e.Score), e.Created.Unix(), n)
+		}
+		f, err := os.OpenFile(filepath.Join(reg.dir, e.File), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !os.IsExist(err) {
+			return f, err
+		}
+	}
+}
+
+// Ranked returns the models scored with fitness from highest score to lowest.
+// Scores from different fitness functions can't be compared, so with an empty
+// fitness every model is returned grouped by the one it was scored with.
+func (reg *registry) Ranked(fitness string) []*modelEntry {
+	var ranked []*modelEntry
+	for _, e := range reg.Models {
+		if fitness == "" || e.Fitness == fitness {
+			ranked = append(ranked, e)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].Fitness != ranked[j].Fitness {
+			return ranked[i].Fitness < ranked[j].Fitness
+		}
+		return ranked[i].Score > ranked[j].Score
+	})
+	return ranked
+}
+
+// rankFitness is the fitness function whose scores pick the best models when
+// none has been chosen by hand
+func rankFitness() string {
+	if f := viper.GetString("rank_fitness"); f != "" {
+		return f
+	}
+	return "median-whale"
+}
+
+func (reg *registry) Promoted() *modelEntry {
+	for _, e := range reg.Models {
+		if e.Promoted {
+			return e
+		}
+	}
+	return nil
+}
+
+func (reg *registry) Promote(file string) error {
+	if err := reg.reload(); err != nil {
+		return err
+	}
+	e := reg.Get(file)
+	if e == nil {
+		return fmt.Errorf("no model %q", file)
+	}
+	for _, other := range reg.Models {
+		other.Promoted = other == e
+	}
+	return reg.Save()
+}
+
+// Update changes a model's entry and saves the manifest
+func (reg *registry) Update(file string, change func(*modelEntry)) error {
+	if err := reg.reload(); err != nil {
+		return err
+	}
+	e := reg.Get(file)
+	if e == nil {
+		return fmt.Errorf("no model %q", file)
+	}
+	change(e)
+	return reg.Save()
+}
+
+func (reg *registry) Load(e *modelEntry) (betNet, error) {
+	return netFromFile(filepath.Join(reg.dir, e.File))
+}
+
+// promotedNet loads the promoted network from dir, falling back to the best
+// scoring one by rank_fitness if nothing has been promoted yet
+func promotedNet(dir string) (betNet, error) {
+	reg, err := loadRegistry(dir)
+	if err != nil {
+		return betNet{}, err
+	}
+	e := reg.Promoted()
+	if e == nil {
+		ranked := reg.Ranked(rankFitness())
+		if len(ranked) == 0 {
+			return betNet{}, fmt.Errorf("no promoted model and none scored by %s in %s", rankFitness(), dir)
+		}
+		e = ranked[0]
+		log.Printf("warning: no promoted model, using best %s score %s", e.Fitness, e.File)
+	}
+	return reg.Load(e)
+}
+
+// registryEntry finds the registry and model that a backtest's -net path
+// refers to: a model file in a registry directory, or the directory itself
+// for its promoted model
+func registryEntry(netPath string) (*registry, *modelEntry, error) {
+	dir, file := filepath.Dir(netPath), filepath.Base(netPath)
+	if st, err := os.Stat(netPath); err == nil && st.IsDir() {
+		dir, file = netPath, ""
+	}
+	reg, err := loadRegistry(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	e := reg.Promoted()
+	if file != "" {
+		e = reg.Get(file)
+	}
+	if e == nil {
+		return nil, nil, fmt.Errorf("%s is not a registered model", netPath)
+	}
+	return reg, e, nil
+}
+
+// modelsCmd implements the list, promote and diff commands
+func modelsCmd(args []string) {
+	if len(args) < 1 {
+		log.Fatalln("usage: models list | promote <file> | diff <file> <file>")
+	}
+	reg, err := loadRegistry("_bnet")
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+	switch args[0] {
+	case "list":
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "\tFILE\tSCORE\tFITNESS\tDATA\tFEATURES")
+		for _, e := range reg.Ranked("") {
+			mark := ""
+			if e.Promoted {
+				mark = "*"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", mark, e.File, fmtNum(e.Score), e.Fitness, e.dataRange(), e.Features.Key())
+		}
+		w.Flush()
+	case "promote":
+		if len(args) < 2 {
+			log.Fatalln("usage: models promote <file>")
+		}
+		if err := reg.Promote(args[1]); err != nil {
+			log.Fatalln("error:", err)
+		}
+		log.Printf("promoted %s", args[1])
+	case "diff":
+		if len(args) < 3 {
+			log.Fatalln("usage: models diff <file> <file>")
+		}
+		a, b := reg.Get(args[1]), reg.Get(args[2])
+		if a == nil || b == nil {
+			log.Fatalln("error: no such model")
+		}
+		diffModels(a, b)
+	default:
+		log.Fatalln("error: unknown models command", args[0])
+	}
+}
+
+func (e *modelEntry) dataRange() string {
+	if e.DataFrom.IsZero() {
+		return "unknown"
+	}
+	return fmt.Sprintf("%s to %s", e.DataFrom.Format("2006-01-02"), e.DataTo.Format("2006-01-02"))
+}
+
+// diffModels prints the fields that differ between two models
+func diffModels(a, b *modelEntry) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "\t%s\t%s\n", a.File, b.File)
+	row := func(name, x, y string) {
+		if x != y {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", name, x, y)
+		}
+	}
+	row("created", a.Created.Format(time.RFC3339), b.Created.Format(time.RFC3339))
+	row("fitness", a.Fitness, b.Fitness)
+	row("score", fmtNum(a.Score), fmtNum(b.Score))
+	row("data", a.dataRange(), b.dataRange())
+	row("matches", strconv.Itoa(a.Matches), strconv.Itoa(b.Matches))
+	row("layout", fmt.Sprint(a.Inputs, a.Layout), fmt.Sprint(b.Inputs, b.Layout))
+	row("promoted", strconv.FormatBool(a.Promoted), strconv.FormatBool(b.Promoted))
+	added, removed := featureDiff(a.Features, b.Features)
+	if len(added) > 0 || len(removed) > 0 {
+		fmt.Fprintf(w, "features\t-%s\t+%s\n", removed.Key(), added.Key())
+	}
+	keys := make(map[string]bool)
+	for k := range a.Metrics {
+		keys[k] = true
+	}
+	for k := range b.Metrics {
+		keys[k] = true
+	}
+	var names []string
+	for k := range keys {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		row(k, fmtMetric(a.Metrics, k), fmtMetric(b.Metrics, k))
+	}
+	w.Flush()
+}
+
+func featureDiff(a, b featureSet) (added, removed featureSet) {
+	in := func(fs featureSet, name string) bool {
+		for _, f := range fs {
+			if f == name {
+				return true
+			}
+		}
+		return false
+	}
+	for _, name := range b {
+		if !in(a, name) {
+			added = append(added, name)
+		}
+	}
+	for _, name := range a {
+		if !in(b, name) {
+			removed = append(removed, name)
+		}
+	}
+	return
+}
+
+func fmtMetric(m map[string]float64, k string) string {
+	v, ok := m[k]
+	if !ok {
+		return "-"
+	}
+	return strconv.FormatFloat(v, 'g', 6, 64)
+}
+
+// recordRange fills in the span of matches a model was trained on
+func (e *modelEntry) recordRange(recs []*matchRecord) {
+	e.Matches = len(recs)
+	for _, rec := range recs {
+		if e.DataFrom.IsZero() || rec.TS.Before(e.DataFrom) {
+			e.DataFrom = rec.TS
+		}
+		if rec.TS.After(e.DataTo) {
+			e.DataTo = rec.TS
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	deep "github.com/patrikeh/go-deep"
+)
+
+func testNet() betNet {
+	return betNet{deep.NewNeural(betConfig(legacyFeatures)), legacyFeatures}
+}
+
+func TestRegistryLegacyImport(t *testing.T) {
+	dir := t.TempDir()
+	// networks saved before the registry have no manifest or feature list
+	blob, err := json.Marshal(testNet().Dump())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "12345.1600000000.dat"), blob, 0644); err != nil {
+		t.Fatal(err)
+	}
+	reg, err := loadRegistry(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := reg.Get("12345.1600000000.dat")
+	if e == nil {
+		t.Fatalf("legacy network not imported: %+v", reg.Models)
+	}
+	if e.Fitness != "median-whale" || e.Score != 12345 || !e.Created.Equal(time.Unix(1600000000, 0)) || e.Features.Key() != legacyFeatures.Key() {
+		t.Errorf("imported %+v", e)
+	}
+	if _, err := promotedNet(dir); err != nil {
+		t.Errorf("promotedNet with only a legacy network: %s", err)
+	}
+}
+
+func TestRegistryAdd(t *testing.T) {
+	dir := t.TempDir()
+	reg, err := loadRegistry(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// models with the same score in the same second get distinct files
+	created := time.Unix(1600000000, 0)
+	var files []string
+	for i := 0; i < 3; i++ {
+		f, err := reg.create(&modelEntry{Score: 100, Created: created})
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, filepath.Base(f.Name()))
+		f.Close()
+	}
+	if files[0] != "100.1600000000.dat" || files[1] != "100.1600000000.1.dat" || files[2] != "100.1600000000.2.dat" {
+		t.Errorf("created %v", files)
+	}
+
+	dir = t.TempDir()
+	a, err := loadRegistry(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Add(testNet(), &modelEntry{Fitness: "median-whale", Score: 100}); err != nil {
+		t.Fatal(err)
+	}
+	first := a.Models[0].File
+	// another process promotes the model while this one still has the old
+	// manifest loaded
+	b, err := loadRegistry(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Promote(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Add(testNet(), &modelEntry{Fitness: "median-whale", Score: 200}); err != nil {
+		t.Fatal(err)
+	}
+	reg, err = loadRegistry(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reg.Models) != 2 {
+		t.Fatalf("got %d models, want 2", len(reg.Models))
+	}
+	if p := reg.Promoted(); p == nil || p.File != first {
+		t.Errorf("promotion was lost: %+v", p)
+	}
+	if ranked := reg.Ranked("median-whale"); ranked[0].Score != 200 {
+		t.Errorf("best model is %s", ranked[0].File)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/spf13/viper"
+)
+
+// sizer decides which side to bet on and how much, given a prediction. It
+// returns false if the match shouldn't be bet on.
+type sizer interface {
+	Bet(d *tierData, rec *matchRecord, wg wager, bank float64) (pick int, amount float64, ok bool)
+}
+
+// loadSizer returns the strategy selected by the "sizing" setting. Kelly
+// sizing is calibrated against pred's predictions on the matches returned by
+// history, which must be in the order they were played. If pred is the
+// network netPath refers to, the calibration is kept with it in the registry.
+func loadSizer(pred predictor, netPath string, history func() []*matchRecord) (sizer, error) {
+	switch viper.GetString("sizing") {
+	case "", "scaled":
+		return scaledSizer{}, nil
+	case "kelly":
+		fraction := defaultKellyFraction
+		if viper.IsSet("kelly_fraction") {
+			fraction = viper.GetFloat64("kelly_fraction")
+		}
+		if fraction <= 0 || fraction > 1 {
+			return nil, fmt.Errorf("kelly_fraction %v is not between 0 and 1", fraction)
+		}
+		cal, err := cachedCalibration(pred, netPath, history())
+		if err != nil {
+			return nil, fmt.Errorf("can't calibrate for kelly sizing: %s", err)
+		}
+		return kellySizer{Fraction: fraction, Calibration: cal}, nil
+	default:
+		return nil, fmt.Errorf("unknown sizing %q", viper.GetString("sizing"))
+	}
+}
+
+// houseLimits applies the rules every strategy shares: go all in when the
+// bank is small or close to the bailout anyway, and never more than maxBet.
+// It returns false for modes that aren't bet on.
+func houseLimits(amount, bank float64, mode string) (float64, bool) {
+	bailout := float64(defaultBailout)
+	switch mode {
+	case "matchmaking":
+	case "tournament":
+		bailout = tournBailout
+	default:
+		// exhibs are for suckers
+		return 0, false
+	}
+	if bank-amount < bailout || amount > bank || bank < alwaysAllIn {
+		amount = bank
+	}
+	if amount > maxBet {
+		amount = maxBet
+	}
+	return amount, true
+}
+
+// scaledSizer stakes the predicted size as a fraction of the bank, scaled up
+// by mode
+type scaledSizer struct{}
+
+func (scaledSizer) Bet(d *tierData, rec *matchRecord, wg wager, bank float64) (int, float64, bool) {
+	if wg.Size() <= 0 {
+		return 0, 0, false
+	}
+	amount := bank * wg.Size()
+	switch rec.Mode {
+	case "matchmaking":
+		amount *= mmScale
+	case "tournament":
+		amount *= trnScale
+	}
+	amount, ok := houseLimits(amount, bank, rec.Mode)
+	pick := 0
+	if wg.PredictB() {
+		pick = 1
+	}
+	return pick, amount, ok
+}
+
+const defaultKellyFraction = 0.25
+
+// kellySizer treats the prediction only as a win probability and stakes a
+// fraction of the amount that maximizes expected log bank
+type kellySizer struct {
+	Fraction    float64
+	Calibration platt
+}
+
+func (k kellySizer) Bet(d *tierData, rec *matchRecord, wg wager, bank float64) (int, float64, bool) {
+	pot := expectedPots(d, rec)
+	if pot[0] <= 0 || pot[1] <= 0 {
+		return 0, 0, false
+	}
+	pb := k.Calibration.Prob(wg)
+	// the underdog can be the better bet if the crowd overrates the favorite
+	stakeA, growthA := kellyStake(1-pb, pot[0], pot[1], bank)
+	stakeB, growthB := kellyStake(pb, pot[1], pot[0], bank)
+	pick, stake := 0, stakeA
+	if growthB > growthA {
+		pick, stake = 1, stakeB
+	}
+	amount := math.Floor(stake * k.Fraction)
+	if amount < 1 {
+		return 0, 0, false
+	}
+	amount, ok := houseLimits(amount, bank, rec.Mode)
+	return pick, amount, ok
+}
+
+// kellyStake maximizes expected log bank for a bet that wins with
+// probability p on a side with pot winPot against losePot. Our own wager is
+// added to our side's pot, so the payout per unit shrinks as the stake grows.
+func kellyStake(p, winPot, losePot, bank float64) (stake, growth float64) {
+	f := func(w float64) float64 {
+		return p*math.Log(bank+payoff(w, winPot, losePot)) + (1-p)*math.Log(bank-w) - math.Log(bank)
+	}
+	// no edge at all
+	if p*losePot/winPot <= 1-p {
+		return 0, 0
+	}
+	// f is concave, so a ternary search finds the maximum
+	lo, hi := 0.0, bank*(1-1e-9)
+	for i := 0; i < 100; i++ {
+		m1 := lo + (hi-lo)/3
+		m2 := hi - (hi-lo)/3
+		if f(m1) < f(m2) {
+			lo = m1
+		} else {
+			hi = m2
+		}
+	}
+	stake = (lo + hi) / 2
+	return stake, f(stake)
+}
+
+// expectedPots estimates both sides' pots before betting closes, from the
+// recent average total pot and how heavily each character is usually backed
+func expectedPots(d *tierData, rec *matchRecord) (pot [2]float64) {
+	a, b := d.pair(rec)
+	if a == nil || b == nil || rec.PotAvg <= 0 {
+		return
+	}
+	r := a.CrowdFavor() / b.CrowdFavor()
+	pot[0] = rec.PotAvg * r / (1 + r)
+	pot[1] = rec.PotAvg - pot[0]
+	return
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestKellyStake(t *testing.T) {
+	for _, c := range []struct {
+		name                     string
+		p, winPot, losePot, bank float64
+		noEdge                   bool
+	}{
+		{"coin flip at even pots", 0.5, 1e6, 1e6, 1000, true},
+		{"favorite at fair odds", 0.75, 3e6, 1e6, 1000, true},
+		{"small edge", 0.55, 1e6, 1e6, 1000, false},
+		{"big bank moves the odds", 0.6, 1e5, 1e5, 1e5, false},
+		{"underdog", 0.4, 1e6, 3e6, 5000, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			stake, growth := kellyStake(c.p, c.winPot, c.losePot, c.bank)
+			if c.noEdge {
+				if stake != 0 || growth != 0 {
+					t.Errorf("kellyStake = %v, %v, want no bet", stake, growth)
+				}
+				return
+			}
+			// compare with the best of a fine grid
+			g := func(w float64) float64 {
+				return c.p*math.Log(c.bank+payoff(w, c.winPot, c.losePot)) + (1-c.p)*math.Log(c.bank-w) - math.Log(c.bank)
+			}
+			var best, bestGrowth float64
+			for w := 0.0; w < c.bank; w += c.bank / 100000 {
+				if gw := g(w); gw > bestGrowth {
+					best, bestGrowth = w, gw
+				}
+			}
+			if math.Abs(stake-best) > c.bank/1000 || math.Abs(growth-bestGrowth) > 1e-9 {
+				t.Errorf("kellyStake = %.2f (growth %.6g), want %.2f (growth %.6g)", stake, growth, best, bestGrowth)
+			}
+		})
+	}
+}
+
+func TestPayoff(t *testing.T) {
+	rec := newRecord("A", "winner", "loser", 3000, 1000, 4000, 60)
+	for _, c := range []struct {
+		wager, want float64
+	}{
+		{0, 0},
+		{1000, 250},
+		{3000, 500},
+	} {
+		if got := rec.Payoff(c.wager); math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("Payoff(%v) = %v, want %v", c.wager, got, c.want)
+		}
+	}
+}
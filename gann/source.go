@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// matchSource blocks until the current match differs from lastMatch, or a
+// timeout passes, and then returns the current match
+type matchSource interface {
+	Next(lastMatch matchMeta) (matchMeta, error)
+}
+
+// httpSource long-polls twchat's /current endpoint
+type httpSource struct {
+	URL string
+}
+
+func (s httpSource) Next(lastMatch matchMeta) (matchMeta, error) {
+	return pollMatch(lastMatch, s.URL)
+}
+
+// pgSource listens for notifications from twchat and reads the current_match
+// table
+type pgSource struct {
+	cfg  pgx.ConnConfig
+	conn *pgx.Conn
+}
+
+func newPGSource() (*pgSource, error) {
+	cfg, err := pgx.ParseEnvLibpq()
+	if err != nil {
+		return nil, err
+	}
+	s := &pgSource{cfg: cfg}
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *pgSource) connect() error {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	conn, err := pgx.Connect(s.cfg)
+	if err != nil {
+		return err
+	}
+	if err := conn.Listen("current_match"); err != nil {
+		conn.Close()
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+func (s *pgSource) Next(lastMatch matchMeta) (matchMeta, error) {
+	if s.conn == nil || !s.conn.IsAlive() {
+		if err := s.connect(); err != nil {
+			time.Sleep(time.Second)
+			return matchMeta{}, err
+		}
+	}
+	// listening has already started, so a change between reading and
+	// waiting will still wake us up
+	m, err := s.read()
+	if err != nil || !m.Same(lastMatch) {
+		return m, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	if _, err := s.conn.WaitForNotification(ctx); err != nil && err != context.DeadlineExceeded {
+		return matchMeta{}, err
+	}
+	return s.read()
+}
+
+func (s *pgSource) read() (m matchMeta, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	row := s.conn.QueryRowEx(ctx, "SELECT match_id, p1, p2, tier, mode FROM current_match WHERE channel = $1", nil, watchChannel())
+	err = row.Scan(&m.ID, &m.Name1, &m.Name2, &m.Tier, &m.Mode)
+	if err == pgx.ErrNoRows {
+		err = nil
+	}
+	return
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/mtharp/thorium/schema"
+	"github.com/spf13/viper"
+)
+
+// Store provides the match history gann trains and bets on
+type Store interface {
+	CheckSchema() error
+	Migrate() error
+	// PaidMatches returns paid matches from table in the watched channel
+	// after since, oldest first
+	PaidMatches(table string, since time.Time, modes []string) ([]matchRow, error)
+}
+
+type matchRow struct {
+	ID              string
+	TS              time.Time
+	Tier, Mode      string
+	Winner, Loser   string
+	WinPot, LosePot int64
+	Duration        int
+}
+
+var db Store
+
+// openStore uses the embedded database if a sqlite path is configured, and
+// Postgres otherwise
+func openStore() error {
+	if path := viper.GetString("sqlite"); path != "" {
+		sdb, err := schema.OpenSQLite(path)
+		if err != nil {
+			return err
+		}
+		db = &sqliteStore{sdb}
+	} else {
+		db = pgStore{}
+	}
+	return nil
+}
+
+// modeList formats modes for an IN clause. Modes are fixed strings, never
+// user input.
+func modeList(modes []string) string {
+	return "'" + strings.Join(modes, "', '") + "'"
+}
+
+func paidMatchesQuery(table string, modes []string) string {
+	return fmt.Sprintf("SELECT coalesce(match_id, ''), ts, tier, mode, winner, loser, winpot, losepot, duration FROM %s WHERE outcome = 'paid' AND mode IN (%s) AND ts > $1 AND channel = $2 ORDER BY ts", table, modeList(modes))
+}
+
+type rowScanner interface {
+	Next() bool
+	Scan(dest ...interface{}) error
+	Err() error
+}
+
+func scanMatchRows(rows rowScanner) ([]matchRow, error) {
+	var recs []matchRow
+	for rows.Next() {
+		var r matchRow
+		if err := rows.Scan(&r.ID, &r.TS, &r.Tier, &r.Mode, &r.Winner, &r.Loser, &r.WinPot, &r.LosePot, &r.Duration); err != nil {
+			return nil, err
+		}
+		recs = append(recs, r)
+	}
+	return recs, rows.Err()
+}
+
+// pgStore opens a new connection for each query, since they are infrequent
+type pgStore struct{}
+
+func (pgStore) CheckSchema() error { return schema.CheckEnv() }
+func (pgStore) Migrate() error     { return schema.MigrateEnv() }
+
+func (pgStore) PaidMatches(table string, since time.Time, modes []string) ([]matchRow, error) {
+	cfg, err := pgx.ParseEnvLibpq()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := pgx.Connect(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	rows, err := conn.Query(paidMatchesQuery(table, modes), since, watchChannel())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanMatchRows(rows)
+}
+
+// sqliteStore reads from an embedded database file for local development
+type sqliteStore struct {
+	db *sql.DB
+}
+
+func (s *sqliteStore) CheckSchema() error { return schema.CheckSQLite(s.db) }
+func (s *sqliteStore) Migrate() error     { return schema.MigrateSQLite(s.db) }
+
+func (s *sqliteStore) PaidMatches(table string, since time.Time, modes []string) ([]matchRow, error) {
+	rows, err := s.db.Query(schema.Rebind(paidMatchesQuery(table, modes)), since.UTC(), watchChannel())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanMatchRows(rows)
+}
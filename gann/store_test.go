@@ -0,0 +1,85 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mtharp/thorium/schema"
+)
+
+func openTestStore(t *testing.T) *sqliteStore {
+	sdb, err := schema.OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sdb.Close() })
+	s := &sqliteStore{sdb}
+	if err := s.CheckSchema(); err == nil {
+		t.Error("CheckSchema passed before migrating")
+	}
+	if err := s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CheckSchema(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSQLitePaidMatches(t *testing.T) {
+	s := openTestStore(t)
+	base := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	insert := "INSERT INTO matches (match_id, ts, channel, winner, loser, winpot, losepot, duration, tier, mode, outcome) VALUES (?1, ?2, ?3, 'Ryu', 'Ken', 100, 50, 60, 'A', ?4, ?5)"
+	for i, m := range []struct{ id, channel, mode, outcome string }{
+		{"1", "#saltybet", "matchmaking", "paid"},
+		{"2", "#saltybet", "tournament", "paid"},
+		{"3", "#saltybet", "matchmaking", "void"},
+		{"4", "#other", "matchmaking", "paid"},
+		{"5", "#saltybet", "matchmaking", "paid"},
+	} {
+		if _, err := s.db.Exec(insert, m.id, base.Add(time.Duration(i)*time.Minute), m.channel, m.mode, m.outcome); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []struct {
+		name  string
+		since time.Time
+		modes []string
+		want  []string
+	}{
+		{"all", time.Time{}, []string{"matchmaking", "tournament"}, []string{"1", "2", "5"}},
+		{"matchmaking", time.Time{}, []string{"matchmaking"}, []string{"1", "5"}},
+		{"since", base, []string{"matchmaking", "tournament"}, []string{"2", "5"}},
+	} {
+		rows, err := s.PaidMatches("matches", c.since, c.modes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, r := range rows {
+			ids = append(ids, r.ID)
+		}
+		if len(ids) != len(c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, ids, c.want)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != c.want[i] {
+				t.Errorf("%s: got %v, want %v", c.name, ids, c.want)
+				break
+			}
+		}
+	}
+	rows, err := s.PaidMatches("all_matches", time.Time{}, []string{"matchmaking"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("all_matches: got %d rows", len(rows))
+	}
+	r := rows[1]
+	if !r.TS.Equal(base.Add(4*time.Minute)) || r.Winner != "Ryu" || r.Loser != "Ken" || r.WinPot != 100 || r.LosePot != 50 || r.Duration != 60 || r.Tier != "A" {
+		t.Errorf("row = %+v", r)
+	}
+}
@@ -6,8 +6,6 @@ import (
 	"math/rand"
 	"sort"
 	"strings"
-
-	deep "github.com/patrikeh/go-deep"
 )
 
 const (
@@ -18,11 +16,14 @@ func shuffleRecords(recs []*matchRecord) {
 	rand.Shuffle(len(recs), func(i, j int) { recs[i], recs[j] = recs[j], recs[i] })
 }
 
-func simulateBailout(nn *deep.Neural, recs []*matchRecord) (score float64) {
+func simulateBailout(nn predictor, recs []*matchRecord) (score float64) {
 	bank := simBailout
 	for _, rec := range recs {
 		d := tiers[tierIdx[rec.Tier]]
-		wg := wagerFromVector(nn.Predict(d.BetVector(rec, bank)))
+		wg, _, ok := nn.Wager(d, rec, bank)
+		if !ok {
+			continue
+		}
 		// wager
 		wager := bank * wg.Size() * mmScale
 		if bank-wager < simBailout || wager > bank {
@@ -49,21 +50,18 @@ func simulateBailout(nn *deep.Neural, recs []*matchRecord) (score float64) {
 
 const whaleStart = 1e6
 
-func simulateWhale(nn *deep.Neural, recs []*matchRecord, scale, debug bool) float64 {
+func simulateWhale(nn predictor, ts [5]*tierData, recs []*matchRecord, scale, debug bool) float64 {
 	bank := whaleStart
 	balances := make(sort.Float64Slice, len(recs))
 	for i, rec := range recs {
 		// predict
-		d := tiers[tierIdx[rec.Tier]]
-		v := d.BetVector(rec, bank)
-		if v == nil {
+		d := ts[tierIdx[rec.Tier]]
+		wg, v, ok := nn.Wager(d, rec, bank)
+		if !ok {
 			// no data
 			balances[i] = bank
 			continue
-		} else if len(v) != betVectorSize {
-			panic("betVectorSize is wrong")
 		}
-		wg := wagerFromVector(nn.Predict(v))
 		// wager
 		wager := bank * wg.Size()
 		if scale {
@@ -82,7 +80,7 @@ func simulateWhale(nn *deep.Neural, recs []*matchRecord, scale, debug bool) floa
 		}
 		if debug {
 			//log.Printf("%p score=%f wager=%f vec %s", nn, score, wager, fmtVec(v))
-			log.Printf("%p bank=%f %s wager=%f po=%.3f ppo=%.3f chg=%+f [%s]", nn, bank, res, wager, rec.Payoff(1), change, fmtVec(v))
+			log.Printf("%p bank=%f %s wager=%f po=%.3f chg=%+f [%s]", nn, bank, res, wager, rec.Payoff(1), change, fmtVec(v))
 		}
 		bank += change
 		if bank < simBailout {
@@ -93,7 +91,6 @@ func simulateWhale(nn *deep.Neural, recs []*matchRecord, scale, debug bool) floa
 	}
 	sort.Sort(balances)
 	return balances[len(balances)/10]
-	return bank
 }
 
 func fmtVec(x []float64) string {
@@ -103,32 +100,3 @@ func fmtVec(x []float64) string {
 	}
 	return strings.Join(w, " ")
 }
-
-const betVectorSize = 5
-
-func (d *tierData) BetVector(rec *matchRecord, bank float64) []float64 {
-	rec.bvo.Do(func() {
-		a, b := rec.Name[0], rec.Name[1]
-		astat, bstat := d.chars[a], d.chars[b]
-		if astat == nil || bstat == nil {
-			return
-		}
-		rec.bvc = []float64{
-			astat.WinRate() - bstat.WinRate(),
-			astat.CrowdFavor() - bstat.CrowdFavor(),
-			astat.AvgWinTime() - bstat.AvgWinTime(),
-			leastGames(astat, bstat),
-			float64(tierIdx[rec.Tier]),
-		}
-	})
-	return rec.bvc
-}
-
-func leastGames(astat, bstat *charStats) float64 {
-	agames := astat.Wins + astat.Losses
-	bgames := bstat.Wins + bstat.Losses
-	if bgames < agames {
-		return bgames
-	}
-	return agames
-}
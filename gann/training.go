@@ -34,14 +34,20 @@ func (l scoreList) Swap(i, j int) {
 	l[i], l[j] = l[j], l[i]
 }
 
-func train(ctx context.Context, ncfg *deep.Config, eval evalFunc, rng *rand.Rand) (*deep.Neural, float64) {
-	pop := make([]*deep.Neural, population)
-	for i := 0; i < population; i++ {
-		pop[i] = deep.NewNeural(ncfg)
+// train evolves a population until its best score levels off or ctx is
+// cancelled. st holds the run's progress and is updated after every
+// generation, then checkpoint is called.
+func train(ctx context.Context, ncfg *deep.Config, eval evalFunc, rng *rand.Rand, st *trainState, checkpoint func()) (*deep.Neural, float64) {
+	pop := st.Population
+	if pop == nil {
+		pop = make([]*deep.Neural, population)
+		for i := 0; i < population; i++ {
+			pop[i] = deep.NewNeural(ncfg)
+		}
 	}
-	var prevScores [termStride]float64
+	prevScores := st.PrevScores
 	var lastScore float64
-	for gen := 0; ; gen++ {
+	for gen := st.Generation; ; gen++ {
 		scores := make(scoreList, 0, len(pop))
 		sch := make(chan score, len(pop))
 		for _, nn := range pop {
@@ -92,6 +98,8 @@ func train(ctx context.Context, ncfg *deep.Config, eval evalFunc, rng *rand.Rand
 		}
 		pop = nn2
 		log.Printf("%d %s", gen, fmtNum(lastScore))
+		st.Generation, st.PrevScores, st.Population = gen+1, prevScores, pop
+		checkpoint()
 		if ctx.Err() != nil {
 			break
 		}
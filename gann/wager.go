@@ -1,7 +1,41 @@
 package main
 
+import (
+	"fmt"
+	"os"
+)
+
 type wager float64
 
+// predictor picks a side and a bet size for a match, or returns false if it
+// has no data for one of the characters or otherwise can't call it. The
+// vector is whatever the predictor based its decision on, for logging.
+type predictor interface {
+	Wager(d *tierData, rec *matchRecord, bank float64) (wager, []float64, bool)
+}
+
+// loadPredictor returns the named predictor. Networks are loaded from
+// netPath, which is either a saved network or a model registry directory to
+// load the promoted one from.
+func loadPredictor(name, netPath string) (predictor, error) {
+	switch name {
+	case "", "net":
+		st, err := os.Stat(netPath)
+		if err != nil {
+			return nil, err
+		} else if st.IsDir() {
+			return promotedNet(netPath)
+		}
+		return netFromFile(netPath)
+	case "bt":
+		return btPredictor{}, nil
+	case "ensemble":
+		return loadEnsemble(netPath)
+	default:
+		return nil, fmt.Errorf("unknown predictor %q", name)
+	}
+}
+
 func wagerFromVector(o []float64) wager {
 	switch len(o) {
 	case 2:
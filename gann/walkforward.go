@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	deep "github.com/patrikeh/go-deep"
+)
+
+const walkForwardSlices = 8
+
+// prepWalkForward loads every match in the order it was played and fixes
+// their bet vectors with walkForwardVectors. Matches after until are left out
+// unless it's zero.
+func prepWalkForward(fs featureSet, until time.Time) ([]*matchRecord, [5]*tierData) {
+	recs := recordsUntil(allRecords(), until)
+	return recs, walkForwardVectors(fs, recs)
+}
+
+// walkForwardVectors fixes each record's bet vector from the matches before it
+// and then adds the match to the stats, so no prediction sees its own result
+// or anything later. recs must be in the order they were played. Predictions
+// must use the returned stats to get the fixed vectors.
+func walkForwardVectors(fs featureSet, recs []*matchRecord) [5]*tierData {
+	ts := buildTiers(nil)
+	for _, rec := range recs {
+		d := ts[tierIdx[rec.Tier]]
+		d.BetVector(fs, rec, whaleStart)
+		d.Update([]*matchRecord{rec})
+	}
+	return ts
+}
+
+// chronoSlices splits recs into n consecutive runs of matches
+func chronoSlices(recs []*matchRecord, n int) [][]*matchRecord {
+	sets := make([][]*matchRecord, 0, n)
+	for i := 0; i < n; i++ {
+		lo, hi := len(recs)*i/n, len(recs)*(i+1)/n
+		if hi > lo {
+			sets = append(sets, recs[lo:hi])
+		}
+	}
+	return sets
+}
+
+// trainWalkForward trains on each window of matches and scores the result on
+// the window that follows it. Networks are saved with the score from the
+// window they never saw.
+func trainWalkForward(t *trainer, save func(*deep.Neural, *modelEntry)) {
+	fs := t.ck.Features
+	recs, ts := prepWalkForward(fs, t.ck.DataTo)
+	if len(recs) > 0 {
+		t.ck.DataTo = recs[len(recs)-1].TS
+	}
+	sets := chronoSlices(recs, t.ck.Windows)
+	if len(sets) < 2 {
+		log.Fatalln("error: not enough matches for walk-forward training")
+	}
+	for i := t.ck.Window; i+1 < len(sets) && t.ctx.Err() == nil; i++ {
+		trainSet, testSet := sets[i], sets[i+1]
+		nn, trainScore := t.run(betConfig(fs), medianWhale(fs, ts, chronoSlices(trainSet, walkForwardSlices)))
+		testScore := simulateWhale(betNet{nn, fs}, ts, testSet, false, false)
+		log.Printf("window %d: trained on %s to %s score %s, tested on %s to %s score %s",
+			i+1, fmtDay(trainSet[0]), fmtDay(trainSet[len(trainSet)-1]), fmtNum(trainScore),
+			fmtDay(testSet[0]), fmtDay(testSet[len(testSet)-1]), fmtNum(testScore))
+		e := &modelEntry{
+			Fitness: "walkforward-test",
+			Score:   testScore,
+			Metrics: map[string]float64{"train_score": trainScore, "test_matches": float64(len(testSet))},
+		}
+		e.recordRange(trainSet)
+		save(nn, e)
+		if t.ctx.Err() == nil {
+			t.ck.Window++
+			t.done()
+		}
+	}
+}
+
+func fmtDay(rec *matchRecord) string {
+	return rec.TS.Format("2006-01-02")
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWalkForwardVectors(t *testing.T) {
+	fs := featureSet{"winrate", "favor", "wintime", "leastgames", "tier", "matchup", "rating", "ratingprob", "deviation", "bt", "bank"}
+	games := [][2]string{
+		{"Ryu", "Ken"}, {"Ken", "Guile"}, {"Ryu", "Guile"}, {"Ken", "Ryu"}, {"Guile", "Ryu"}, {"Ryu", "Ken"},
+	}
+	later := [][2]string{{"Ken", "Ryu"}, {"Ken", "Ryu"}, {"Guile", "Ken"}, {"Guile", "Ryu"}}
+	base := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	history := func(games [][2]string) (recs []*matchRecord) {
+		for i, g := range games {
+			rec := newRecord("A", g[0], g[1], int64(100+i), 200, 300, 60+i)
+			rec.Mode = "matchmaking"
+			rec.TS = base.Add(time.Duration(i) * time.Minute)
+			recs = append(recs, rec)
+		}
+		return
+	}
+	vectors := func(recs []*matchRecord) (vs [][]float64) {
+		ts := walkForwardVectors(fs, recs)
+		d := ts[tierIdx["A"]]
+		for _, rec := range recs {
+			vs = append(vs, d.BetVector(fs, rec, whaleStart))
+		}
+		return
+	}
+	short := vectors(history(games))
+	long := vectors(history(append(append([][2]string(nil), games...), later...)))
+	// nobody has stats before their first match
+	if short[0] != nil {
+		t.Errorf("first match has vector %v", short[0])
+	}
+	for i := range short {
+		if len(short[i]) != len(long[i]) {
+			t.Fatalf("match %d: vector %v became %v", i, short[i], long[i])
+		}
+		for j := range short[i] {
+			if short[i][j] != long[i][j] {
+				t.Errorf("match %d: %s changed from %v to %v after later matches", i, fs[j], short[i][j], long[i][j])
+			}
+		}
+	}
+	// a later match's vector reflects the earlier ones
+	if v := long[len(long)-1]; v == nil {
+		t.Error("last match has no vector")
+	}
+}
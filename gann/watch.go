@@ -15,17 +15,17 @@ import (
 	"strings"
 	"time"
 
-	deep "github.com/patrikeh/go-deep"
 	"github.com/spf13/viper"
 )
 
 type matchMeta struct {
+	ID                       string
 	Name1, Name2, Tier, Mode string
 }
 
-func watchAndRun(nn *deep.Neural, metaURL string) {
+func watchAndRun(pred predictor, sz sizer, src matchSource) {
 	var failures int
-	_, ts, avgPot := prepData(false)
+	_, ts, avgPot := prepData(false, time.Time{})
 	jar, _ := cookiejar.New(nil)
 	cli := &http.Client{Jar: jar}
 	uid, bank, err := scrapeHome(cli)
@@ -38,11 +38,11 @@ func watchAndRun(nn *deep.Neural, metaURL string) {
 	lastMode := ""
 	for {
 		// wait for tier info
-		match, err := pollMatch(pending, metaURL)
+		match, err := src.Next(pending)
 		if err != nil {
 			log.Printf("warning: failed to poll match status: %s", err)
 			continue
-		} else if match == pending || match.IsZero() {
+		} else if match.Same(pending) || match.IsZero() {
 			pending = match
 			continue
 		}
@@ -52,7 +52,7 @@ func watchAndRun(nn *deep.Neural, metaURL string) {
 			bankChanged = true
 			if lastMode == "tournament" {
 				log.Printf("tournament ended, resetting data")
-				prepData(false)
+				prepData(false, time.Time{})
 			}
 		}
 		lastMode = match.Mode
@@ -90,7 +90,7 @@ func watchAndRun(nn *deep.Neural, metaURL string) {
 			log.Printf("error: fetching new match records: %s", err)
 		} else {
 			for tier, recs := range tierRecs {
-				tiers[tierIdx[tier]].chars.Update(recs)
+				tiers[tierIdx[tier]].Update(recs)
 				log.Printf("Added %d match record(s) to tier %s", len(recs), tier)
 			}
 			if ts2.After(ts) {
@@ -113,40 +113,18 @@ func watchAndRun(nn *deep.Neural, metaURL string) {
 			log.Printf("no data for %q", match.Name2)
 			continue
 		}
-		rec := newLiveRecord(match.Tier, match.Name1, match.Name2, avgPot)
-		wg := wagerFromVector(nn.Predict(d.BetVector(rec, bank)))
-		if wg.Size() <= 0 {
-			log.Printf("too close to call")
+		rec := newLiveRecord(match.Tier, match.Mode, match.Name1, match.Name2, avgPot)
+		wg, v, ok := pred.Wager(d, rec, bank)
+		if !ok {
+			log.Printf("no prediction [%s]", fmtVec(v))
 			continue
 		}
-
-		base := bank * wg.Size()
-		wager := base
-		bailout := float64(defaultBailout)
-		switch match.Mode {
-		case "matchmaking":
-			wager *= mmScale
-		case "tournament":
-			wager *= trnScale
-			bailout = tournBailout
-		case "exhibitions":
-			log.Printf("exhibs are for suckers")
-			continue
-		default:
-			log.Printf("unknown mode %q", match.Mode)
+		idx, wager, ok := sz.Bet(d, rec, wg, bank)
+		if !ok {
+			log.Printf("not betting: prediction=%.3f mode=%q", float64(wg), match.Mode)
 			continue
 		}
-		if bank-wager < bailout || wager > bank || bank < alwaysAllIn {
-			wager = bank
-		}
-		if wager > maxBet {
-			wager = maxBet
-		}
-		log.Printf("base=%s adj=%s avgpot=%s", fmtNum(base), fmtNum(wager), fmtNum(avgPot))
-		idx = 0
-		if wg.PredictB() {
-			idx = 1
-		}
+		log.Printf("wager=%s avgpot=%s", fmtNum(wager), fmtNum(avgPot))
 		iwager := int(wager)
 		dwager := iwager
 		suffix := ""
@@ -154,7 +132,7 @@ func watchAndRun(nn *deep.Neural, metaURL string) {
 			dwager /= 1000
 			suffix = "k"
 		}
-		log.Printf("Placing %d%s on %q", dwager, suffix, rec.Name[idx])
+		log.Printf("Placing %d%s on %q match=%s", dwager, suffix, rec.Name[idx], match.ID)
 		//continue
 		var p int
 		if rec.Name[idx] == match.Name1 {
@@ -193,7 +171,7 @@ func pollMatch(lastMatch matchMeta, u string) (matchMeta, error) {
 	if err != nil {
 		return matchMeta{}, err
 	}
-	v := make(url.Values)
+	v := req.URL.Query()
 	v.Set("p1", lastMatch.Name1)
 	v.Set("p2", lastMatch.Name2)
 	req.URL.RawQuery = v.Encode()
@@ -290,6 +268,13 @@ func scrapeHome(cli *http.Client) (uid string, bank float64, err error) {
 	return
 }
 
+// Same reports whether m and o are the same fight. twchat assigns the ID when
+// bets lock, so it isn't compared.
+func (m matchMeta) Same(o matchMeta) bool {
+	m.ID = o.ID
+	return m == o
+}
+
 func (m matchMeta) IsZero() bool {
 	return m.Name1 == "" || m.Name2 == "" || m.Tier == "" || m.Mode == ""
 }
@@ -6,26 +6,24 @@ import (
 	"time"
 
 	"github.com/jackc/pgx"
+	"github.com/mtharp/thorium/schema"
 )
 
 const stmtBank = "update_bank"
 
-type DB struct {
+// pgStore keeps banks in Postgres
+type pgStore struct {
 	*pgx.ConnPool
 	banktotals chan bankUpdate
 }
 
-type bankUpdate struct {
-	Name string
-	Bank int64
-}
-
-var db *DB
-
-func connectDB() error {
+func connectPG() (*pgStore, error) {
+	if err := schema.CheckEnv(); err != nil {
+		return nil, err
+	}
 	cfg, err := pgx.ParseEnvLibpq()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	pool, err := pgx.NewConnPool(pgx.ConnPoolConfig{
 		ConnConfig: cfg,
@@ -35,62 +33,48 @@ func connectDB() error {
 		},
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	db = &DB{
+	db := &pgStore{
 		ConnPool:   pool,
 		banktotals: make(chan bankUpdate, 1000),
 	}
-	go db.bankUpdater()
-	return nil
+	go bankUpdater(db.banktotals, db.sendBatch)
+	return db, nil
 }
 
-func (db *DB) SetBank(username string, bank int64) {
+func (db *pgStore) SetBank(username string, bank int64) {
 	select {
 	case db.banktotals <- bankUpdate{username, bank}:
 	default:
 	}
 }
 
-func (db *DB) AddHistory(username string, bank int64) {
+func (db *pgStore) AddHistory(username string, bank int64, matchID string) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	_, err := db.ExecEx(ctx, "INSERT INTO bank_history (username, bank) VALUES ($1, $2)", nil, username, bank)
+	var nmatch *string
+	if matchID != "" {
+		nmatch = &matchID
+	}
+	_, err := db.ExecEx(ctx, "INSERT INTO bank_history (username, bank, match_id) VALUES ($1, $2, $3) ON CONFLICT (username, match_id) DO NOTHING", nil, username, bank, nmatch)
 	if err != nil {
 		log.Printf("error: updating bank history: %s", err)
 	}
 }
 
-func (db *DB) bankUpdater() {
-	var banks []bankUpdate
-	t := time.NewTimer(0)
-	for {
-		select {
-		case <-t.C:
-			if len(banks) == 0 {
-				t.Reset(time.Hour)
-				continue
-			}
-			if err := db.sendBatch(banks); err != nil {
-				log.Printf("error: updating bank totals: %s", err)
-			}
-			banks = banks[:0]
-		case item := <-db.banktotals:
-			banks = append(banks, item)
-			if len(banks) > 250 {
-				if err := db.sendBatch(banks); err != nil {
-					log.Printf("error: updating bank totals: %s", err)
-				}
-				banks = banks[:0]
-				t.Reset(time.Hour)
-			} else {
-				t.Reset(time.Second)
-			}
-		}
+func (db *pgStore) MatchID(channel, p1, p2 string, since time.Time) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var id string
+	err := db.QueryRowEx(ctx, matchIDQuery, nil, channel, p1, p2, since).Scan(&id)
+	if err == pgx.ErrNoRows {
+		err = nil
 	}
+	return id, err
 }
 
-func (db *DB) sendBatch(banks []bankUpdate) error {
+func (db *pgStore) sendBatch(banks []bankUpdate) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	start := time.Now()
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/mtharp/thorium/schema"
+)
+
+// sqliteStore keeps banks in an embedded database file
+type sqliteStore struct {
+	db         *sql.DB
+	banktotals chan bankUpdate
+}
+
+func openSQLite(path string) (*sqliteStore, error) {
+	sdb, err := schema.OpenSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := schema.CheckSQLite(sdb); err != nil {
+		sdb.Close()
+		return nil, err
+	}
+	db := &sqliteStore{
+		db:         sdb,
+		banktotals: make(chan bankUpdate, 1000),
+	}
+	go bankUpdater(db.banktotals, db.sendBatch)
+	return db, nil
+}
+
+func (db *sqliteStore) SetBank(username string, bank int64) {
+	select {
+	case db.banktotals <- bankUpdate{username, bank}:
+	default:
+	}
+}
+
+func (db *sqliteStore) AddHistory(username string, bank int64, matchID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var nmatch *string
+	if matchID != "" {
+		nmatch = &matchID
+	}
+	_, err := db.db.ExecContext(ctx, "INSERT OR IGNORE INTO bank_history (ts, username, bank, match_id) VALUES (?1, ?2, ?3, ?4)", time.Now().UTC(), username, bank, nmatch)
+	if err != nil {
+		log.Printf("error: updating bank history: %s", err)
+	}
+}
+
+func (db *sqliteStore) MatchID(channel, p1, p2 string, since time.Time) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var id string
+	err := db.db.QueryRowContext(ctx, schema.Rebind(matchIDQuery), channel, p1, p2, since.UTC()).Scan(&id)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return id, err
+}
+
+func (db *sqliteStore) sendBatch(banks []bankUpdate) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	for _, item := range banks {
+		if item.Name == "" || item.Bank == 0 {
+			continue
+		}
+		_, err := tx.ExecContext(ctx, "INSERT INTO banks (username, bank, best, last) VALUES (?1, ?2, ?2, ?3) ON CONFLICT (username) DO UPDATE SET bank = ?2, best = max(?2, banks.best), last = ?3", item.Name, item.Bank, now)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
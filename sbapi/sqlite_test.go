@@ -0,0 +1,126 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mtharp/thorium/schema"
+)
+
+func openTestStore(t *testing.T) *sqliteStore {
+	path := filepath.Join(t.TempDir(), "test.db")
+	sdb, err := schema.OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = schema.MigrateSQLite(sdb)
+	sdb.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := openSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.db.Close() })
+	return db
+}
+
+func TestMatchID(t *testing.T) {
+	db := openTestStore(t)
+	now := time.Now()
+	lookup := func(p1, p2 string, since time.Time) string {
+		t.Helper()
+		id, err := db.MatchID("#saltybet", p1, p2, since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	exec := func(q string, args ...interface{}) {
+		t.Helper()
+		if _, err := db.db.Exec(q, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if id := lookup("Ryu", "Ken", now); id != "" {
+		t.Errorf("no match: got %q", id)
+	}
+	// while the match is current
+	exec("INSERT INTO current_match (channel, match_id, p1, p2, tier, mode) VALUES ('#saltybet', 'm2', 'Ryu', 'Ken', 'A', 'matchmaking')")
+	if id := lookup("Ryu", "Ken", now); id != "m2" {
+		t.Errorf("current match: got %q", id)
+	}
+	if id := lookup("Ken", "Ryu", now); id != "" {
+		t.Errorf("current match with sides swapped: got %q", id)
+	}
+	// after twchat records the result and clears the current match
+	insert := "INSERT INTO matches (match_id, ts, channel, winner, loser, winpot, losepot, duration, tier, mode) VALUES (?1, ?2, '#saltybet', ?3, ?4, 1, 1, 60, 'A', 'matchmaking')"
+	exec(insert, "m1", now.Add(-time.Hour).UTC(), "Ryu", "Ken")
+	exec(insert, "m2", now.UTC(), "Ken", "Ryu")
+	exec("DELETE FROM current_match")
+	if id := lookup("Ryu", "Ken", now.Add(-time.Minute)); id != "m2" {
+		t.Errorf("recorded match: got %q", id)
+	}
+	if id := lookup("Ryu", "Ken", now.Add(time.Minute)); id != "" {
+		t.Errorf("only old matches: got %q", id)
+	}
+	if id, err := db.MatchID("#other", "Ryu", "Ken", now.Add(-2*time.Hour)); err != nil || id != "" {
+		t.Errorf("other channel: got %q, %v", id, err)
+	}
+}
+
+func TestAddHistory(t *testing.T) {
+	db := openTestStore(t)
+	db.AddHistory("alice", 100, "m1")
+	db.AddHistory("alice", 100, "m1")
+	db.AddHistory("bob", 200, "m1")
+	db.AddHistory("alice", 150, "")
+	db.AddHistory("alice", 175, "")
+	var n int
+	if err := db.db.QueryRow("SELECT count(*) FROM bank_history WHERE match_id = 'm1'").Scan(&n); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Errorf("got %d rows for m1, want one per user", n)
+	}
+	if err := db.db.QueryRow("SELECT count(*) FROM bank_history WHERE match_id IS NULL").Scan(&n); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Errorf("got %d rows without a match, want 2", n)
+	}
+}
+
+func TestSendBatch(t *testing.T) {
+	db := openTestStore(t)
+	err := db.sendBatch([]bankUpdate{{"alice", 100}, {"bob", 200}, {"", 300}, {"carol", 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.sendBatch([]bankUpdate{{"alice", 50}, {"bob", 250}}); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := db.db.Query("SELECT username, bank, best FROM banks ORDER BY username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []bankUpdate
+	var bests []int64
+	for rows.Next() {
+		var u bankUpdate
+		var best int64
+		if err := rows.Scan(&u.Name, &u.Bank, &best); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, u)
+		bests = append(bests, best)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []bankUpdate{{"alice", 50}, {"bob", 250}}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || bests[0] != 100 || bests[1] != 250 {
+		t.Errorf("banks = %v, best %v", got, bests)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/mtharp/thorium/schema"
+	"github.com/spf13/viper"
+)
+
+// Store records bank totals and history
+type Store interface {
+	// SetBank queues an update to a user's bank total
+	SetBank(username string, bank int64)
+	AddHistory(username string, bank int64, matchID string)
+	// MatchID returns the ID twchat assigned to the match between p1 and p2,
+	// whether it is still current or was recorded after since, or "" if there
+	// is no such match
+	MatchID(channel, p1, p2 string, since time.Time) (string, error)
+}
+
+// twchat clears the current match once it records the result, so the ID is
+// looked up in both places
+const matchIDQuery = `SELECT match_id FROM current_match WHERE channel = $1 AND p1 = $2 AND p2 = $3
+	UNION ALL
+	SELECT match_id FROM matches WHERE channel = $1 AND match_id IS NOT NULL AND ts >= $4
+		AND ((winner = $2 AND loser = $3) OR (winner = $3 AND loser = $2))
+	LIMIT 1`
+
+type bankUpdate struct {
+	Name string
+	Bank int64
+}
+
+var db Store
+
+// connectDB opens the embedded database if a sqlite path is configured, and
+// Postgres otherwise
+func connectDB() (err error) {
+	if path := viper.GetString("sqlite"); path != "" {
+		db, err = openSQLite(path)
+	} else {
+		db, err = connectPG()
+	}
+	return
+}
+
+func migrateDB() error {
+	if path := viper.GetString("sqlite"); path != "" {
+		sdb, err := schema.OpenSQLite(path)
+		if err != nil {
+			return err
+		}
+		defer sdb.Close()
+		return schema.MigrateSQLite(sdb)
+	}
+	return schema.MigrateEnv()
+}
+
+// bankUpdater batches queued bank totals and sends them at most once a second
+func bankUpdater(banktotals <-chan bankUpdate, send func([]bankUpdate) error) {
+	var banks []bankUpdate
+	t := time.NewTimer(0)
+	for {
+		select {
+		case <-t.C:
+			if len(banks) == 0 {
+				t.Reset(time.Hour)
+				continue
+			}
+			if err := send(banks); err != nil {
+				log.Printf("error: updating bank totals: %s", err)
+			}
+			banks = banks[:0]
+		case item := <-banktotals:
+			banks = append(banks, item)
+			if len(banks) > 250 {
+				if err := send(banks); err != nil {
+					log.Printf("error: updating bank totals: %s", err)
+				}
+				banks = banks[:0]
+				t.Reset(time.Hour)
+			} else {
+				t.Reset(time.Second)
+			}
+		}
+	}
+}
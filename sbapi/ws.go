@@ -9,6 +9,7 @@ import (
 	"log"
 	"net/http"
 	"net/http/cookiejar"
+	"os"
 	"path"
 	"strconv"
 	"strings"
@@ -32,6 +33,8 @@ const (
 	staleTimeout = 15 * time.Minute
 
 	fetchHoldoff = time.Second
+	// matchLookback allows for chat timestamps running behind the site
+	matchLookback = 5 * time.Minute
 )
 
 var (
@@ -117,7 +120,7 @@ func keepalive(conn *websocket.Conn, pongch chan bool, donech chan struct{}) {
 			conn.Close()
 			return
 		case <-stale.C:
-			log.Printf("error: no data from websocket for %s, reconnecting")
+			log.Printf("error: no data from websocket for %s, reconnecting", staleTimeout)
 			conn.Close()
 			return
 		}
@@ -128,6 +131,15 @@ var cli *http.Client
 
 func main() {
 	viper.AutomaticEnv()
+	if len(os.Args) > 1 {
+		if os.Args[1] != "migrate" {
+			log.Fatalln("usage: sbapi [migrate]")
+		}
+		if err := migrateDB(); err != nil {
+			log.Fatalln("error:", err)
+		}
+		return
+	}
 	for _, name := range viper.GetStringSlice("watch") {
 		watching[name] = true
 	}
@@ -212,10 +224,20 @@ type playerData struct {
 var (
 	lastStatus     string
 	lastP1, lastP2 string
+	lockedAt       time.Time
 	mode           string
 	banks          = make(map[string]playerData)
 )
 
+// watchChannel returns the chat channel that twchat records this site's
+// matches under
+func watchChannel() string {
+	if ch := viper.GetString("channel"); ch != "" {
+		return ch
+	}
+	return "#saltybet"
+}
+
 func strfield(d interface{}) string {
 	s, _ := d.(string)
 	return s
@@ -226,7 +248,7 @@ func intfield(d interface{}) int64 {
 	return n
 }
 
-func update(db *DB) error {
+func update(db Store) error {
 	d, err := fetch(stateURL)
 	if err != nil {
 		return err
@@ -244,6 +266,7 @@ func update(db *DB) error {
 		}
 		lastP1 = strfield(d["p1name"])
 		lastP2 = strfield(d["p2name"])
+		lockedAt = time.Now()
 		p1total := intfield(d["p1total"])
 		p2total := intfield(d["p2total"])
 		mode = ""
@@ -283,6 +306,12 @@ func update(db *DB) error {
 		} else if strfield(d["p1name"]) != lastP1 || strfield(d["p2name"]) != lastP2 {
 			return errors.New("player mismatch")
 		}
+		// twchat assigns the ID when bets lock in chat, which can be after
+		// the site shows them locked, so wait until payout to look it up
+		matchID, err := db.MatchID(watchChannel(), lastP1, lastP2, lockedAt.Add(-matchLookback))
+		if err != nil {
+			log.Printf("error: looking up match ID: %s", err)
+		}
 		for name, data := range banks {
 			change := -data.wager
 			result := "lose"
@@ -297,7 +326,7 @@ func update(db *DB) error {
 			if watching[name] {
 				log.Printf("[%11s] %s %s %+d -> %d", mode, name, result, change, data.bank)
 				if mode != "tournament" {
-					db.AddHistory(name, data.bank)
+					db.AddHistory(name, data.bank, matchID)
 				}
 			}
 		}
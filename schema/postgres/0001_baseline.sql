@@ -0,0 +1,50 @@
+-- Tables as they existed before migrations were tracked. Everything is
+-- created only if missing so this can be applied to an existing database.
+
+CREATE TABLE IF NOT EXISTS banks (
+    username text PRIMARY KEY,
+    bank bigint NOT NULL,
+    best bigint NOT NULL,
+    last timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS bank_history (
+    ts timestamptz NOT NULL DEFAULT now(),
+    username text NOT NULL,
+    bank bigint NOT NULL
+);
+CREATE INDEX IF NOT EXISTS bank_history_username_ts ON bank_history (username, ts);
+
+CREATE TABLE IF NOT EXISTS matches (
+    ts timestamptz NOT NULL DEFAULT now(),
+    winner text NOT NULL,
+    loser text NOT NULL,
+    winpot bigint NOT NULL,
+    losepot bigint NOT NULL,
+    duration integer NOT NULL,
+    tier text NOT NULL,
+    mode text NOT NULL
+);
+CREATE INDEX IF NOT EXISTS matches_ts ON matches (ts);
+
+CREATE TABLE IF NOT EXISTS current_match (
+    p1 text NOT NULL,
+    p2 text NOT NULL,
+    tier text NOT NULL,
+    mode text NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS tokens (
+    name text PRIMARY KEY,
+    token bytea NOT NULL
+);
+
+-- all_matches is what gann trains on. Older databases have it as a table of
+-- imported history; new ones just see the live matches.
+DO $$
+BEGIN
+    IF to_regclass('all_matches') IS NULL THEN
+        CREATE VIEW all_matches AS SELECT * FROM matches;
+    END IF;
+END
+$$;
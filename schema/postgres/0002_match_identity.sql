@@ -0,0 +1,51 @@
+-- Void outcomes, channels, match IDs and unparsed line tracking
+
+ALTER TABLE matches
+    ADD COLUMN IF NOT EXISTS match_id text,
+    ADD COLUMN IF NOT EXISTS channel text NOT NULL DEFAULT '#saltybet',
+    ADD COLUMN IF NOT EXISTS outcome text NOT NULL DEFAULT 'paid',
+    ADD COLUMN IF NOT EXISTS reason text;
+CREATE UNIQUE INDEX IF NOT EXISTS matches_match_id ON matches (match_id);
+CREATE INDEX IF NOT EXISTS matches_winner ON matches (winner);
+CREATE INDEX IF NOT EXISTS matches_loser ON matches (loser);
+
+DO $$
+BEGIN
+    IF (SELECT relkind FROM pg_class WHERE oid = to_regclass('all_matches')) = 'v' THEN
+        CREATE OR REPLACE VIEW all_matches AS SELECT * FROM matches;
+    ELSE
+        ALTER TABLE all_matches
+            ADD COLUMN IF NOT EXISTS match_id text,
+            ADD COLUMN IF NOT EXISTS channel text NOT NULL DEFAULT '#saltybet',
+            ADD COLUMN IF NOT EXISTS outcome text NOT NULL DEFAULT 'paid',
+            ADD COLUMN IF NOT EXISTS reason text;
+    END IF;
+END
+$$;
+
+-- one current match per channel
+DELETE FROM current_match;
+ALTER TABLE current_match
+    ADD COLUMN IF NOT EXISTS match_id text NOT NULL,
+    ADD COLUMN IF NOT EXISTS channel text NOT NULL;
+ALTER TABLE current_match ADD PRIMARY KEY (channel);
+
+ALTER TABLE bank_history ADD COLUMN IF NOT EXISTS match_id text;
+
+-- tokens are now stored encrypted rather than as JSON
+DO $$
+BEGIN
+    IF (SELECT data_type FROM information_schema.columns WHERE table_name = 'tokens' AND column_name = 'token') <> 'bytea' THEN
+        ALTER TABLE tokens ALTER COLUMN token TYPE bytea USING convert_to(token::text, 'UTF8');
+    END IF;
+END
+$$;
+
+CREATE TABLE IF NOT EXISTS unparsed_lines (
+    template text PRIMARY KEY,
+    first_seen timestamptz NOT NULL,
+    last_seen timestamptz NOT NULL,
+    nick text NOT NULL,
+    text text NOT NULL,
+    count bigint NOT NULL
+);
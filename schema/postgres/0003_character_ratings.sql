@@ -0,0 +1,13 @@
+-- Glicko-2 ratings computed by gann, per channel and tier
+
+CREATE TABLE IF NOT EXISTS character_ratings (
+    channel text NOT NULL,
+    tier text NOT NULL,
+    name text NOT NULL,
+    rating double precision NOT NULL,
+    deviation double precision NOT NULL,
+    volatility double precision NOT NULL,
+    games integer NOT NULL,
+    updated timestamptz NOT NULL,
+    PRIMARY KEY (channel, tier, name)
+);
@@ -0,0 +1,6 @@
+-- one bank history row per user and match, so a repeated payout isn't counted
+-- twice
+
+DELETE FROM bank_history a USING bank_history b
+    WHERE a.username = b.username AND a.match_id = b.match_id AND a.ctid > b.ctid;
+CREATE UNIQUE INDEX IF NOT EXISTS bank_history_username_match_id ON bank_history (username, match_id);
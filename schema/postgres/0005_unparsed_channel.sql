@@ -0,0 +1,6 @@
+-- unparsed lines are counted per channel, since each channel has its own
+-- parser. Lines recorded before this have no channel.
+
+ALTER TABLE unparsed_lines ADD COLUMN IF NOT EXISTS channel text NOT NULL DEFAULT '';
+ALTER TABLE unparsed_lines DROP CONSTRAINT IF EXISTS unparsed_lines_pkey;
+ALTER TABLE unparsed_lines ADD PRIMARY KEY (channel, template);
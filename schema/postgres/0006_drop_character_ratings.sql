@@ -0,0 +1,4 @@
+-- gann recomputes ratings from the match history every time it starts, so the
+-- stored copy was never read
+
+DROP TABLE IF EXISTS character_ratings;
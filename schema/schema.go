@@ -0,0 +1,192 @@
+// Package schema holds the database migrations shared by all of the services.
+package schema
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx"
+)
+
+//go:embed postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+// Dialects with their own set of migrations. Both sets use the same version
+// numbers.
+const (
+	Postgres = "postgres"
+	SQLite   = "sqlite"
+)
+
+// lockID is the advisory lock held while migrating, so that services
+// starting at the same time don't race
+const lockID = 0x7468726d
+
+// DB is satisfied by both *pgx.Conn and *pgx.ConnPool
+type DB interface {
+	Begin() (*pgx.Tx, error)
+	QueryRow(sql string, args ...interface{}) *pgx.Row
+}
+
+type migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+func migrations(dialect string) ([]migration, error) {
+	names, err := fs.Glob(files, dialect+"/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	var ms []migration
+	for _, name := range names {
+		base := path.Base(name)
+		version, err := strconv.Atoi(strings.SplitN(base, "_", 2)[0])
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: invalid version", base)
+		}
+		blob, err := files.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, migration{version, strings.TrimSuffix(base, ".sql"), string(blob)})
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Version < ms[j].Version })
+	for i, m := range ms {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %s: expected version %d", m.Name, i+1)
+		}
+	}
+	return ms, nil
+}
+
+// Latest returns the schema version this binary expects
+func Latest() int {
+	pg, err := migrations(Postgres)
+	if err != nil {
+		panic(err)
+	}
+	lite, err := migrations(SQLite)
+	if err != nil {
+		panic(err)
+	} else if len(lite) != len(pg) {
+		panic("schema: postgres and sqlite migrations are out of step")
+	}
+	return len(pg)
+}
+
+// Version returns the version the database is at, or 0 if migrations have
+// never been applied
+func Version(db DB) (int, error) {
+	var version int
+	err := db.QueryRow("SELECT coalesce(max(version), 0) FROM schema_migrations").Scan(&version)
+	if pgErr, ok := err.(pgx.PgError); ok && pgErr.Code == "42P01" {
+		// undefined_table
+		return 0, nil
+	}
+	return version, err
+}
+
+// Check returns an error if the database is older than this binary expects.
+// A newer database is accepted, so that migrations can be run before the
+// binaries using them are deployed; migrations should leave the previous
+// release's queries working where they can.
+func Check(db DB) error {
+	version, err := Version(db)
+	if err != nil {
+		return fmt.Errorf("checking schema version: %s", err)
+	}
+	return checkVersion(version)
+}
+
+func checkVersion(version int) error {
+	latest := Latest()
+	switch {
+	case version < latest:
+		return fmt.Errorf("database schema is at version %d but %d is required, run the migrate command", version, latest)
+	case version > latest:
+		log.Printf("warning: database schema is at version %d, newer than this binary's %d", version, latest)
+	}
+	return nil
+}
+
+// Migrate applies any migrations that are not yet in the database. Each one
+// runs in its own transaction.
+func Migrate(db DB) error {
+	ms, err := migrations(Postgres)
+	if err != nil {
+		return err
+	}
+	for _, m := range ms {
+		applied, err := apply(db, m)
+		if err != nil {
+			return fmt.Errorf("migration %s: %s", m.Name, err)
+		} else if applied {
+			log.Printf("applied migration %s", m.Name)
+		}
+	}
+	return nil
+}
+
+func apply(db DB, m migration) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", lockID); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version integer PRIMARY KEY, name text NOT NULL, applied timestamptz NOT NULL DEFAULT now())"); err != nil {
+		return false, err
+	}
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", m.Version).Scan(&exists); err != nil {
+		return false, err
+	} else if exists {
+		return false, nil
+	}
+	if _, err := tx.Exec(m.SQL); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// MigrateEnv connects using the libpq environment variables and migrates
+func MigrateEnv() error {
+	conn, err := connectEnv()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return Migrate(conn)
+}
+
+// CheckEnv connects using the libpq environment variables and checks the
+// schema version
+func CheckEnv() error {
+	conn, err := connectEnv()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return Check(conn)
+}
+
+func connectEnv() (*pgx.Conn, error) {
+	cfg, err := pgx.ParseEnvLibpq()
+	if err != nil {
+		return nil, err
+	}
+	return pgx.Connect(cfg)
+}
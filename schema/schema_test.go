@@ -0,0 +1,85 @@
+package schema
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrationsInStep(t *testing.T) {
+	pg, err := migrations(Postgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lite, err := migrations(SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pg) != len(lite) {
+		t.Fatalf("%d postgres migrations but %d sqlite", len(pg), len(lite))
+	}
+	for i := range pg {
+		if pg[i].Name != lite[i].Name {
+			t.Errorf("migration %d is %s for postgres but %s for sqlite", i+1, pg[i].Name, lite[i].Name)
+		}
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	latest := Latest()
+	for _, c := range []struct {
+		version int
+		ok      bool
+	}{
+		{0, false},
+		{latest - 1, false},
+		{latest, true},
+		{latest + 1, true},
+	} {
+		if err := checkVersion(c.version); (err == nil) != c.ok {
+			t.Errorf("checkVersion(%d) = %v", c.version, err)
+		}
+	}
+}
+
+func TestMigrateSQLite(t *testing.T) {
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := CheckSQLite(db); err == nil || !strings.Contains(err.Error(), "migrate") {
+		t.Fatalf("CheckSQLite on an empty database = %v", err)
+	}
+	if err := MigrateSQLite(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckSQLite(db); err != nil {
+		t.Fatal(err)
+	}
+	// migrating again does nothing
+	if err := MigrateSQLite(db); err != nil {
+		t.Fatal(err)
+	}
+	// a database migrated by a newer release is still usable
+	if _, err := db.Exec(fmt.Sprintf("PRAGMA user_version = %d", Latest()+1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckSQLite(db); err != nil {
+		t.Errorf("CheckSQLite on a newer database = %v", err)
+	}
+}
+
+func TestRebind(t *testing.T) {
+	for q, want := range map[string]string{
+		"SELECT 1":                              "SELECT 1",
+		"WHERE a = $1 AND b = $2 OR c = $1":     "WHERE a = ?1 AND b = ?2 OR c = ?1",
+		"VALUES ($9, $10, $11)":                 "VALUES (?9, ?10, ?11)",
+		"SET last_seen = $3, count = count + 1": "SET last_seen = ?3, count = count + 1",
+	} {
+		if got := Rebind(q); got != want {
+			t.Errorf("Rebind(%q) = %q, want %q", q, got, want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package schema
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+
+	// pure Go driver, registered as "sqlite"
+	_ "modernc.org/sqlite"
+)
+
+// OpenSQLite opens an embedded database file for local development
+func OpenSQLite(path string) (*sql.DB, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)&_time_format=sqlite", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+var placeholder = regexp.MustCompile(`\$([0-9]+)`)
+
+// Rebind converts postgres style $N placeholders to the ?N form sqlite
+// understands
+func Rebind(q string) string {
+	return placeholder.ReplaceAllString(q, "?$1")
+}
+
+// CheckSQLite returns an error if the embedded database is older than this
+// binary expects
+func CheckSQLite(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("checking schema version: %s", err)
+	}
+	return checkVersion(version)
+}
+
+// MigrateSQLite applies any migrations not yet in the embedded database. The
+// version is tracked in user_version.
+func MigrateSQLite(db *sql.DB) error {
+	ms, err := migrations(SQLite)
+	if err != nil {
+		return err
+	}
+	for _, m := range ms {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		var version int
+		if err := tx.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+			tx.Rollback()
+			return err
+		} else if version >= m.Version {
+			tx.Rollback()
+			continue
+		}
+		if _, err := tx.Exec(m.SQL); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %s: %s", m.Name, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", m.Version)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("applied migration %s", m.Name)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+-- Timestamps are stored as text in UTC so that they sort correctly.
+
+CREATE TABLE banks (
+    username text PRIMARY KEY,
+    bank integer NOT NULL,
+    best integer NOT NULL,
+    last timestamp NOT NULL
+);
+
+CREATE TABLE bank_history (
+    ts timestamp NOT NULL,
+    username text NOT NULL,
+    bank integer NOT NULL
+);
+CREATE INDEX bank_history_username_ts ON bank_history (username, ts);
+
+CREATE TABLE matches (
+    ts timestamp NOT NULL,
+    winner text NOT NULL,
+    loser text NOT NULL,
+    winpot integer NOT NULL,
+    losepot integer NOT NULL,
+    duration integer NOT NULL,
+    tier text NOT NULL,
+    mode text NOT NULL
+);
+CREATE INDEX matches_ts ON matches (ts);
+
+CREATE TABLE current_match (
+    p1 text NOT NULL,
+    p2 text NOT NULL,
+    tier text NOT NULL,
+    mode text NOT NULL
+);
+
+CREATE TABLE tokens (
+    name text PRIMARY KEY,
+    token blob NOT NULL
+);
+
+CREATE VIEW all_matches AS SELECT * FROM matches;
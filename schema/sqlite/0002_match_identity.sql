@@ -0,0 +1,33 @@
+-- Void outcomes, channels, match IDs and unparsed line tracking
+
+ALTER TABLE matches ADD COLUMN match_id text;
+ALTER TABLE matches ADD COLUMN channel text NOT NULL DEFAULT '#saltybet';
+ALTER TABLE matches ADD COLUMN outcome text NOT NULL DEFAULT 'paid';
+ALTER TABLE matches ADD COLUMN reason text;
+CREATE UNIQUE INDEX matches_match_id ON matches (match_id);
+CREATE INDEX matches_winner ON matches (winner);
+CREATE INDEX matches_loser ON matches (loser);
+
+DROP VIEW all_matches;
+CREATE VIEW all_matches AS SELECT * FROM matches;
+
+DROP TABLE current_match;
+CREATE TABLE current_match (
+    channel text PRIMARY KEY,
+    match_id text NOT NULL,
+    p1 text NOT NULL,
+    p2 text NOT NULL,
+    tier text NOT NULL,
+    mode text NOT NULL
+);
+
+ALTER TABLE bank_history ADD COLUMN match_id text;
+
+CREATE TABLE unparsed_lines (
+    template text PRIMARY KEY,
+    first_seen timestamp NOT NULL,
+    last_seen timestamp NOT NULL,
+    nick text NOT NULL,
+    text text NOT NULL,
+    count integer NOT NULL
+);
@@ -0,0 +1,13 @@
+-- Glicko-2 ratings computed by gann, per channel and tier
+
+CREATE TABLE character_ratings (
+    channel text NOT NULL,
+    tier text NOT NULL,
+    name text NOT NULL,
+    rating real NOT NULL,
+    deviation real NOT NULL,
+    volatility real NOT NULL,
+    games integer NOT NULL,
+    updated timestamp NOT NULL,
+    PRIMARY KEY (channel, tier, name)
+);
@@ -0,0 +1,7 @@
+-- one bank history row per user and match, so a repeated payout isn't counted
+-- twice
+
+DELETE FROM bank_history WHERE rowid NOT IN (
+    SELECT min(rowid) FROM bank_history GROUP BY username, match_id
+) AND match_id IS NOT NULL;
+CREATE UNIQUE INDEX bank_history_username_match_id ON bank_history (username, match_id);
@@ -0,0 +1,17 @@
+-- unparsed lines are counted per channel, since each channel has its own
+-- parser. Lines recorded before this have no channel.
+
+CREATE TABLE unparsed_lines_new (
+    channel text NOT NULL DEFAULT '',
+    template text NOT NULL,
+    first_seen timestamp NOT NULL,
+    last_seen timestamp NOT NULL,
+    nick text NOT NULL,
+    text text NOT NULL,
+    count integer NOT NULL,
+    PRIMARY KEY (channel, template)
+);
+INSERT INTO unparsed_lines_new (template, first_seen, last_seen, nick, text, count)
+    SELECT template, first_seen, last_seen, nick, text, count FROM unparsed_lines;
+DROP TABLE unparsed_lines;
+ALTER TABLE unparsed_lines_new RENAME TO unparsed_lines;
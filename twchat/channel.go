@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// channelConfig describes one channel to watch. It is read from the
+// "channels" list in the config file.
+type channelConfig struct {
+	Name string
+	// Bots are the nicks whose messages are trusted
+	Bots []string
+	// Profile names the parser used for the bots' messages
+	Profile string
+}
+
+var defaultChannels = []channelConfig{{
+	Name:    "#saltybet",
+	Bots:    []string{"waifu4u", "saltybet"},
+	Profile: "saltybet",
+}}
+
+// parserProfile holds the patterns for one bot message format
+type parserProfile struct {
+	Open, Closed, Paid, Mode, Ignore *regexp.Regexp
+	// Refund matches the bot saying a match's bets were returned
+	Refund *regexp.Regexp
+}
+
+var (
+	closedPart = `.*?(?:\(([^)]+)\) )?- \$(.*)`
+	tierPart   = `(?:.|None)`
+
+	profiles = map[string]*parserProfile{
+		"saltybet": {
+			Open:   regexp.MustCompile(`Bets are OPEN for (.*) vs (.*)! \((?:(.*) Tier|Requested by .*?)\)(?: \(.*\))? (?:\((.*)\) www.saltybet.com|(tournament) bracket.*)$`),
+			Closed: regexp.MustCompile(`Bets are locked\. ` + closedPart + `, ` + closedPart),
+			Paid:   regexp.MustCompile(`.* wins! Payouts to Team (.*)\. (.*)!`),
+			Mode:   regexp.MustCompile(`^(Tournament|Matchmaking|Exhibitions) will start shortly`),
+			Refund: regexp.MustCompile(`(?i)\bbets? (?:have been |were |are )?refunded`),
+			Ignore: regexp.MustCompile(`^(wtfSalt |wtfVeku Note:|Current pot|Current stage|Current odds|Download WAIFU Wars|.* by.*, .* by.*|` + tierPart + `(?: / ` + tierPart + `)? Tier$|The current game mode is:|The current tournament bracket|Palettes of previous match:|.* vs .* was requested by|Join the official Salty Bet)`),
+		},
+	}
+)
+
+// loadChannels returns the configured channels, or #saltybet if none are
+// configured. The first channel is the primary one.
+func loadChannels() ([]channelConfig, error) {
+	var channels []channelConfig
+	if err := viper.UnmarshalKey("channels", &channels); err != nil {
+		return nil, err
+	}
+	if len(channels) == 0 {
+		return defaultChannels, nil
+	}
+	for i, ch := range channels {
+		if !strings.HasPrefix(ch.Name, "#") {
+			ch.Name = "#" + ch.Name
+		}
+		ch.Name = strings.ToLower(ch.Name)
+		if ch.Profile == "" {
+			ch.Profile = "saltybet"
+		}
+		if len(ch.Bots) == 0 {
+			// no line would ever be accepted
+			return nil, fmt.Errorf("channel %s has no bots", ch.Name)
+		}
+		channels[i] = ch
+	}
+	return channels, nil
+}
+
+// channelWatcher follows the match lifecycle in one channel
+type channelWatcher struct {
+	channel string
+	bots    map[string]bool
+	parser  *parserProfile
+	hub     *matchHub
+
+	mr matchRecord
+}
+
+func newWatchers(channels []channelConfig) (map[string]*channelWatcher, error) {
+	watchers := make(map[string]*channelWatcher, len(channels))
+	for i, ch := range channels {
+		parser := profiles[ch.Profile]
+		if parser == nil {
+			return nil, fmt.Errorf("channel %s: unknown parser profile %q", ch.Name, ch.Profile)
+		}
+		w := &channelWatcher{
+			channel: ch.Name,
+			bots:    make(map[string]bool),
+			parser:  parser,
+			hub:     newMatchHub(),
+		}
+		for _, bot := range ch.Bots {
+			w.bots[strings.ToLower(bot)] = true
+		}
+		watchers[ch.Name] = w
+		hubs[ch.Name] = w.hub
+		if i == 0 {
+			primaryChannel = ch.Name
+		}
+	}
+	return watchers, nil
+}
+
+// handle follows one bot line. server is false if sent is only when the line
+// was received.
+func (w *channelWatcher) handle(sent time.Time, server bool, nick, text string) {
+	p := w.parser
+	if m := p.Open.FindStringSubmatch(text); m != nil {
+		if w.mr.Phase == phaseLocked {
+			// previous match never paid out
+			recordVoid(w.mr, voidNoPayout)
+		}
+		w.mr = matchRecord{
+			Channel: w.channel,
+			Name1:   m[1],
+			Name2:   m[2],
+			Tier:    m[3],
+			Mode:    m[4],
+			Phase:   phaseOpen,
+		}
+		if w.mr.Mode == "" && m[5] == "tournament" {
+			w.mr.Mode = "tournament"
+		}
+		log.Printf("%s bets open: red=%s blue=%s tier=%s mode=%s", w.channel, m[1], m[2], m[3], w.mr.Mode)
+		if err := setCurrentMatch(w.mr); err != nil {
+			log.Printf("error: setting current match: %s", err)
+		}
+		w.hub.Publish(w.mr)
+	} else if m := p.Closed.FindStringSubmatch(text); m != nil {
+		log.Printf("%s bets locked: streakRed=%s potRed=%s streakBlue=%s potBlue=%s", w.channel, m[1], m[2], m[3], m[4])
+		if w.mr.Phase == phaseOpen {
+			w.mr.Start = sent
+			w.mr.Streak1, _ = strconv.ParseInt(m[1], 10, 64)
+			w.mr.Pot1, _ = strconv.ParseInt(strings.Replace(m[2], ",", "", -1), 10, 64)
+			w.mr.Streak2, _ = strconv.ParseInt(m[3], 10, 64)
+			w.mr.Pot2, _ = strconv.ParseInt(strings.Replace(m[4], ",", "", -1), 10, 64)
+			w.mr.Phase = phaseLocked
+			if !server {
+				log.Printf("warning: %s lock has no server timestamp, so its match ID is from when it was received", w.channel)
+			}
+			w.mr.ID = matchID(w.channel, w.mr.Name1, w.mr.Name2, w.mr.Tier, sent)
+			log.Printf("%s match id=%s", w.channel, w.mr.ID)
+			if err := setCurrentMatch(w.mr); err != nil {
+				log.Printf("error: setting current match: %s", err)
+			}
+			w.hub.Publish(w.mr)
+		}
+	} else if m := p.Mode.FindStringSubmatch(text); m != nil {
+		log.Printf("%s match over: mode=%s", w.channel, strings.ToLower(m[1]))
+		if w.mr.Phase == phaseLocked {
+			// mode switch but no match result
+			w.mr.Stop = sent
+			recordVoid(w.mr, voidModeChange)
+			if err := clearCurrentMatch(w.channel); err != nil {
+				log.Printf("error: setting current match: %s", err)
+			}
+			w.hub.Publish(matchRecord{})
+		}
+		w.mr.Phase = ""
+	} else if m := p.Paid.FindStringSubmatch(text); m != nil {
+		log.Printf("%s match over: winner=%s remaining=%s", w.channel, m[1], m[2])
+		if w.mr.Phase == phaseLocked {
+			w.mr.Stop = sent
+			// record before clearing so that sbapi can always find the ID in
+			// one table or the other
+			switch m[1] {
+			case "Red", "Blue":
+				w.mr.TwoWins = m[1] == "Blue"
+				w.mr.Phase = phasePaid
+				recordMatch(w.mr)
+				// keep the result visible for a little while
+				w.hub.PublishFor(w.mr, paidLinger)
+			default:
+				w.mr.Phase = ""
+				recordVoid(w.mr, voidUnknownWinner)
+				w.hub.Publish(matchRecord{})
+			}
+			if err := clearCurrentMatch(w.channel); err != nil {
+				log.Printf("error: setting current match: %s", err)
+			}
+		} else {
+			w.mr.Phase = ""
+		}
+	} else if p.Refund.MatchString(text) {
+		log.Printf("%s bets refunded", w.channel)
+		if w.mr.Phase == phaseLocked {
+			w.mr.Stop = sent
+			recordRefund(w.mr)
+			if err := clearCurrentMatch(w.channel); err != nil {
+				log.Printf("error: setting current match: %s", err)
+			}
+			w.hub.Publish(matchRecord{})
+		}
+		w.mr.Phase = ""
+	} else if !p.Ignore.MatchString(text) {
+		log.Printf("%s %q", w.channel, text)
+		if err := recordUnparsed(sent, w.channel, nick, text); err != nil {
+			log.Printf("error: recording unparsed line: %s", err)
+		}
+	}
+}
+
+// disconnected voids any match that was in progress when chat was lost
+func (w *channelWatcher) disconnected() {
+	if w.mr.Phase == phaseLocked {
+		recordVoid(w.mr, voidDisconnected)
+	}
+	w.mr.Phase = ""
+}
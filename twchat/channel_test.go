@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestSaltybetRefund(t *testing.T) {
+	p := profiles["saltybet"]
+	for _, c := range []struct {
+		text   string
+		refund bool
+	}{
+		{"Bets have been refunded.", true},
+		{"All bets refunded due to a technical issue", true},
+		{"Bets are locked. Foo (5) - $1,000, Bar (3) - $2,000", false},
+		{"Foo wins! Payouts to Team Red. 5 more matches until the next tournament!", false},
+	} {
+		if got := p.Refund.MatchString(c.text); got != c.refund {
+			t.Errorf("Refund matches %q = %v, want %v", c.text, got, c.refund)
+		}
+	}
+}
@@ -3,54 +3,291 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	subscriberBuffer = 16
+	pushKeepalive    = 30 * time.Second
+	wsWriteTimeout   = 10 * time.Second
 )
 
+// matchHub holds the current match and fans out changes to subscribers.
+// Subscribers that fall behind are evicted by closing their channel.
+type matchHub struct {
+	mu   sync.Mutex
+	cur  matchRecord
+	seq  int
+	subs map[chan matchRecord]bool
+}
+
 var (
-	cmu   sync.Mutex
-	cwait = make(map[*http.Request]chan struct{})
-	cur   matchRecord
+	// hubs are keyed by channel name
+	hubs           = make(map[string]*matchHub)
+	primaryChannel string
 )
 
-func currentMatchNotify(rec matchRecord) {
-	cmu.Lock()
-	cur = rec
-	for _, waitch := range cwait {
-		waitch <- struct{}{}
+func newMatchHub() *matchHub {
+	return &matchHub{subs: make(map[chan matchRecord]bool)}
+}
+
+// hubFor returns the hub for the channel named in the request, or the
+// primary channel if none was given
+func hubFor(rw http.ResponseWriter, req *http.Request) *matchHub {
+	channel := strings.ToLower(req.FormValue("channel"))
+	if channel == "" {
+		channel = primaryChannel
+	} else if !strings.HasPrefix(channel, "#") {
+		channel = "#" + channel
+	}
+	h := hubs[channel]
+	if h == nil {
+		http.Error(rw, "unknown channel", 404)
+	}
+	return h
+}
+
+func (h *matchHub) Publish(rec matchRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.publish(rec)
+}
+
+// PublishFor publishes rec and then clears the current match after d, unless
+// something else was published in the meantime.
+func (h *matchHub) PublishFor(rec matchRecord, d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.publish(rec)
+	seq := h.seq
+	time.AfterFunc(d, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.seq == seq {
+			h.publish(matchRecord{})
+		}
+	})
+}
+
+func (h *matchHub) publish(rec matchRecord) {
+	h.cur = rec
+	h.seq++
+	for ch := range h.subs {
+		select {
+		case ch <- rec:
+		default:
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (h *matchHub) Current() matchRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.cur
+}
+
+// Subscribe returns the current match and a channel that receives every
+// subsequent change. The channel is closed if the subscriber is evicted or
+// cancel is called.
+func (h *matchHub) Subscribe() (rec matchRecord, ch <-chan matchRecord, cancel func()) {
+	sub := make(chan matchRecord, subscriberBuffer)
+	h.mu.Lock()
+	h.subs[sub] = true
+	rec = h.cur
+	h.mu.Unlock()
+	cancel = func() {
+		h.mu.Lock()
+		if h.subs[sub] {
+			delete(h.subs, sub)
+			close(sub)
+		}
+		h.mu.Unlock()
 	}
-	cmu.Unlock()
+	return rec, sub, cancel
 }
 
 func (s *TokenServer) viewCurrent(rw http.ResponseWriter, req *http.Request) {
-	p1 := req.FormValue("p1")
-	p2 := req.FormValue("p2")
-	waitch := make(chan struct{}, 1)
-	cmu.Lock()
-	rec := cur
-	if rec.Name1 != p1 || rec.Name2 != p2 {
-		cmu.Unlock()
-		blob, _ := json.Marshal(rec)
-		rw.Write(blob)
+	hub := hubFor(rw, req)
+	if hub == nil {
 		return
 	}
-	cwait[req] = waitch
-	cmu.Unlock()
-	defer func() {
-		cmu.Lock()
-		delete(cwait, req)
-		cmu.Unlock()
-	}()
-	ctx, cancel := context.WithTimeout(req.Context(), 15*time.Second)
+	p1 := req.FormValue("p1")
+	p2 := req.FormValue("p2")
+	rec, ch, cancel := hub.Subscribe()
 	defer cancel()
-	select {
-	case <-waitch:
-	case <-ctx.Done():
+	if rec.Name1 == p1 && rec.Name2 == p2 {
+		ctx, cancel := context.WithTimeout(req.Context(), 15*time.Second)
+		defer cancel()
+		select {
+		case next, ok := <-ch:
+			if ok {
+				rec = next
+			} else {
+				rec = hub.Current()
+			}
+		case <-ctx.Done():
+		}
 	}
-	cmu.Lock()
-	rec = cur
-	cmu.Unlock()
 	blob, _ := json.Marshal(rec)
 	rw.Write(blob)
 }
+
+// viewEvents streams every change to the current match as server-sent events
+func (s *TokenServer) viewEvents(rw http.ResponseWriter, req *http.Request) {
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming not supported", 500)
+		return
+	}
+	hub := hubFor(rw, req)
+	if hub == nil {
+		return
+	}
+	rec, ch, cancel := hub.Subscribe()
+	defer cancel()
+	h := rw.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	keepalive := time.NewTicker(pushKeepalive)
+	defer keepalive.Stop()
+	send := true
+	for {
+		if send {
+			blob, _ := json.Marshal(rec)
+			if _, err := fmt.Fprintf(rw, "event: current\ndata: %s\n\n", blob); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+		select {
+		case next, ok := <-ch:
+			if !ok {
+				return
+			}
+			rec, send = next, true
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(rw, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+			send = false
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+var upgrader = websocket.Upgrader{
+	// the feed is public and read-only, so allow browser overlays on any origin
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+// viewSocket pushes every change to the current match over a websocket
+func (s *TokenServer) viewSocket(rw http.ResponseWriter, req *http.Request) {
+	hub := hubFor(rw, req)
+	if hub == nil {
+		return
+	}
+	conn, err := upgrader.Upgrade(rw, req, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	rec, ch, cancel := hub.Subscribe()
+	defer cancel()
+	// discard anything the client sends, and notice when it goes away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+	ping := time.NewTicker(pushKeepalive)
+	defer ping.Stop()
+	send := true
+	for {
+		if send {
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteJSON(rec); err != nil {
+				return
+			}
+		}
+		select {
+		case next, ok := <-ch:
+			if !ok {
+				log.Printf("warning: evicted slow websocket client %s", req.RemoteAddr)
+				return
+			}
+			rec, send = next, true
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+			send = false
+		case <-closed:
+			return
+		}
+	}
+}
+
+// matchJSON is the public form of matchRecord. Pots, odds and streaks are
+// only present once bets are locked, and the winner once paid.
+type matchJSON struct {
+	ID               string `json:",omitempty"`
+	Channel          string `json:",omitempty"`
+	Name1, Name2     string
+	Tier, Mode       string
+	Phase            string     `json:",omitempty"`
+	Pot1, Pot2       int64      `json:",omitempty"`
+	Odds1, Odds2     float64    `json:",omitempty"`
+	Streak1, Streak2 int64      `json:",omitempty"`
+	Winner           string     `json:",omitempty"`
+	Start, Stop      *time.Time `json:",omitempty"`
+}
+
+func (r matchRecord) MarshalJSON() ([]byte, error) {
+	m := matchJSON{
+		ID:      r.ID,
+		Channel: r.Channel,
+		Name1:   r.Name1,
+		Name2:   r.Name2,
+		Tier:    r.Tier,
+		Mode:    r.Mode,
+		Phase:   r.Phase,
+	}
+	if r.Phase == phaseLocked || r.Phase == phasePaid {
+		m.Pot1, m.Pot2 = r.Pot1, r.Pot2
+		m.Streak1, m.Streak2 = r.Streak1, r.Streak2
+		// payout per unit wagered on each side
+		if r.Pot1 > 0 && r.Pot2 > 0 {
+			m.Odds1 = float64(r.Pot2) / float64(r.Pot1)
+			m.Odds2 = float64(r.Pot1) / float64(r.Pot2)
+		}
+		if !r.Start.IsZero() {
+			m.Start = &r.Start
+		}
+	}
+	if r.Phase == phasePaid {
+		m.Winner = "red"
+		if r.TwoWins {
+			m.Winner = "blue"
+		}
+		if !r.Stop.IsZero() {
+			m.Stop = &r.Stop
+		}
+	}
+	return json.Marshal(m)
+}
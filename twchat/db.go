@@ -6,24 +6,45 @@ import (
 	"log"
 	"time"
 
-	"github.com/jackc/pgx"
 	"golang.org/x/oauth2"
 )
 
-var db *pgx.ConnPool
+// Reasons recorded for matches that ended without a payout
+const (
+	voidModeChange    = "mode_change"
+	voidNoPayout      = "no_payout"
+	voidUnknownWinner = "unknown_winner"
+	voidDisconnected  = "disconnected"
+	refundAnnounced   = "announced"
+)
 
-func connectDB() error {
-	cfg, err := pgx.ParseEnvLibpq()
-	if err != nil {
-		return err
+func recordMatch(rec matchRecord) {
+	insertMatch(rec, "paid", "")
+}
+
+// recordVoid writes a match that never paid out. Red and blue are stored as
+// winner and loser respectively.
+func recordVoid(rec matchRecord, reason string) {
+	recordUnpaid(rec, "void", reason)
+}
+
+// recordRefund writes a match whose bets the bot said were refunded
+func recordRefund(rec matchRecord) {
+	recordUnpaid(rec, "refunded", refundAnnounced)
+}
+
+func recordUnpaid(rec matchRecord, outcome, reason string) {
+	rec.TwoWins = false
+	if insertMatch(rec, outcome, reason) {
+		log.Printf("recorded %s match: red=%s blue=%s reason=%s", outcome, rec.Name1, rec.Name2, reason)
 	}
-	db, err = pgx.NewConnPool(pgx.ConnPoolConfig{ConnConfig: cfg})
-	return err
 }
 
-func recordMatch(rec matchRecord) {
+// insertMatch stores a finished match, timed by the line that ended it, and
+// reports whether a new row was written
+func insertMatch(rec matchRecord, outcome, reason string) bool {
 	if rec.Tier == "" || len(rec.Tier) > 1 {
-		return
+		return false
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -36,59 +57,79 @@ func recordMatch(rec matchRecord) {
 		winner, loser = rec.Name1, rec.Name2
 		winpot, losepot = rec.Pot1, rec.Pot2
 	}
-	dur := int(rec.Stop.Sub(rec.Start).Round(time.Second).Seconds())
-	_, err := db.ExecEx(ctx, "INSERT INTO matches (winner, loser, winpot, losepot, duration, tier, mode) VALUES ($1, $2, $3, $4, $5, $6, $7)", nil,
-		winner, loser, winpot, losepot, dur, rec.Tier, rec.Mode)
+	var dur int
+	if !rec.Start.IsZero() && !rec.Stop.IsZero() {
+		dur = int(rec.Stop.Sub(rec.Start).Round(time.Second).Seconds())
+	}
+	// the server's time for the line that ended the match, if there was one
+	ts := rec.Stop
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+
+	inserted, err := db.InsertMatch(ctx, storedMatch{
+		ID:       rec.ID,
+		Channel:  rec.Channel,
+		Time:     ts,
+		Winner:   winner,
+		Loser:    loser,
+		WinPot:   winpot,
+		LosePot:  losepot,
+		Duration: dur,
+		Tier:     rec.Tier,
+		Mode:     rec.Mode,
+		Outcome:  outcome,
+		Reason:   reason,
+	})
 	if err != nil {
 		log.Printf("error: recording match: %s", err)
+		return false
+	} else if !inserted {
+		log.Printf("match %s was already recorded", rec.ID)
+		return false
 	}
+	health.MatchRecorded(time.Now())
+	return true
 }
 
 func setCurrentMatch(rec matchRecord) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	txn, err := db.BeginEx(ctx, nil)
-	if err != nil {
-		return err
-	}
-	defer txn.Rollback()
-	_, err = txn.ExecEx(ctx, "DELETE FROM current_match", nil)
-	if err != nil {
-		return err
-	}
-	_, err = txn.ExecEx(ctx, "INSERT INTO current_match (p1, p2, tier, mode) VALUES ($1, $2, $3, $4)", nil, rec.Name1, rec.Name2, rec.Tier, rec.Mode)
-	if err != nil {
-		return err
-	}
-	txn.ExecEx(ctx, "NOTIFY current_match", nil)
-	return txn.CommitEx(ctx)
+	return db.SetCurrentMatch(ctx, rec)
 }
 
-func clearCurrentMatch() error {
+func clearCurrentMatch(channel string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	_, err := db.ExecEx(ctx, "DELETE FROM current_match", nil)
-	return err
+	return db.ClearCurrentMatch(ctx, channel)
 }
 
 func getToken() (*oauth2.Token, error) {
-	row := db.QueryRow("SELECT token FROM tokens WHERE name = 'twitch'")
-	var blob []byte
-	if err := row.Scan(&blob); err == pgx.ErrNoRows {
-		return nil, nil
-	} else if err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	blob, err := db.GetToken(ctx, "twitch")
+	if err != nil || blob == nil {
+		return nil, err
+	}
+	plain, _, err := openToken("twitch", blob)
+	if err != nil {
 		return nil, err
 	}
 	t := new(oauth2.Token)
-	err := json.Unmarshal(blob, t)
+	err = json.Unmarshal(plain, t)
 	return t, err
 }
 
 func putToken(t *oauth2.Token) error {
-	blob, err := json.Marshal(t)
+	plain, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	blob, err := sealToken("twitch", plain)
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec("INSERT INTO tokens (name, token) VALUES ('twitch', $1) ON CONFLICT (name) DO UPDATE SET token = $1", blob)
-	return err
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return db.PutToken(ctx, "twitch", blob)
 }
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// healthState tracks how fresh our view of chat is
+type healthState struct {
+	mu          sync.Mutex
+	started     time.Time
+	everLogged  bool
+	connected   bool
+	changed     time.Time
+	lastLine    time.Time
+	lastMatch   time.Time
+	tokenExpiry time.Time
+	tokenErr    error
+}
+
+var health = &healthState{started: time.Now(), changed: time.Now()}
+
+func (h *healthState) SetConnected(connected bool) {
+	h.mu.Lock()
+	h.connected = connected
+	h.everLogged = h.everLogged || connected
+	h.changed = time.Now()
+	h.mu.Unlock()
+}
+
+// BotLine is called for every line received from a trusted bot
+func (h *healthState) BotLine(t time.Time) {
+	h.mu.Lock()
+	h.lastLine = t
+	h.mu.Unlock()
+}
+
+func (h *healthState) MatchRecorded(t time.Time) {
+	h.mu.Lock()
+	h.lastMatch = t
+	h.mu.Unlock()
+}
+
+// TokenRefreshed records the outcome of the latest attempt to get a token
+func (h *healthState) TokenRefreshed(expiry time.Time, err error) {
+	h.mu.Lock()
+	if err == nil {
+		h.tokenExpiry = expiry
+	}
+	h.tokenErr = err
+	h.mu.Unlock()
+}
+
+type healthReport struct {
+	Database string
+	// IRC is connecting until the first login completes, then connected or
+	// disconnected
+	IRC         string
+	LastLine    string `json:",omitempty"`
+	LastMatch   string `json:",omitempty"`
+	TokenExpiry string `json:",omitempty"`
+	Problems    []string
+}
+
+// check compares the current state against the configured thresholds. Ages
+// are measured from startup until the first line is seen. Only bot lines are
+// checked for staleness since exhibition matches are never recorded.
+func (h *healthState) check(useToken bool) healthReport {
+	maxDisconnect := durationSetting("health_max_disconnect", 5*time.Minute)
+	maxLineAge := durationSetting("health_max_line_age", 10*time.Minute)
+	tokenGrace := durationSetting("health_token_grace", 90*time.Minute)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	r := healthReport{IRC: "connected", Problems: []string{}}
+	d := now.Sub(h.changed).Round(time.Second)
+	switch {
+	case !h.everLogged:
+		r.IRC = "connecting"
+		if d > maxDisconnect {
+			r.Problems = append(r.Problems, fmt.Sprintf("IRC login not complete after %s", d))
+		}
+	case !h.connected:
+		r.IRC = "disconnected"
+		if d > maxDisconnect {
+			r.Problems = append(r.Problems, fmt.Sprintf("IRC disconnected for %s", d))
+		}
+	}
+	ref := h.lastLine
+	if ref.IsZero() {
+		ref = h.started
+	}
+	if d := now.Sub(ref).Round(time.Second); d > maxLineAge {
+		r.Problems = append(r.Problems, fmt.Sprintf("no bot line for %s", d))
+	}
+	if !h.lastLine.IsZero() {
+		r.LastLine = now.Sub(h.lastLine).Round(time.Second).String()
+	}
+	if !h.lastMatch.IsZero() {
+		r.LastMatch = now.Sub(h.lastMatch).Round(time.Second).String()
+	}
+	if useToken {
+		if !h.tokenExpiry.IsZero() {
+			r.TokenExpiry = h.tokenExpiry.Sub(now).Round(time.Second).String()
+			if now.Sub(h.tokenExpiry) > tokenGrace {
+				r.Problems = append(r.Problems, "token expired and was not refreshed")
+			}
+		}
+		if h.tokenErr != nil {
+			r.Problems = append(r.Problems, "token refresh failed: "+h.tokenErr.Error())
+		}
+	}
+	return r
+}
+
+func durationSetting(key string, def time.Duration) time.Duration {
+	if d := viper.GetDuration(key); d > 0 {
+		return d
+	}
+	return def
+}
+
+func (s *TokenServer) viewHealth(rw http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), 5*time.Second)
+	defer cancel()
+	r := health.check(!s.Anonymous)
+	r.Database = "OK"
+	if err := db.Ping(ctx); err != nil {
+		r.Database = err.Error()
+		r.Problems = append(r.Problems, "database: "+err.Error())
+	}
+	status := http.StatusOK
+	if len(r.Problems) != 0 {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSONStatus(rw, status, r)
+}
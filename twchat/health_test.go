@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealthIRCState(t *testing.T) {
+	long := time.Now().Add(-time.Hour)
+	h := &healthState{started: long, changed: long, lastLine: time.Now()}
+	r := h.check(false)
+	if r.IRC != "connecting" || len(r.Problems) != 1 || !strings.HasPrefix(r.Problems[0], "IRC login not complete") {
+		t.Errorf("before login: %s %q", r.IRC, r.Problems)
+	}
+	h.SetConnected(true)
+	if r = h.check(false); r.IRC != "connected" || len(r.Problems) != 0 {
+		t.Errorf("after login: %s %q", r.IRC, r.Problems)
+	}
+	h.SetConnected(false)
+	h.changed = long
+	if r = h.check(false); r.IRC != "disconnected" || len(r.Problems) != 1 || !strings.HasPrefix(r.Problems[0], "IRC disconnected") {
+		t.Errorf("after disconnect: %s %q", r.IRC, r.Problems)
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+type historyMatch struct {
+	ID              string
+	Time            time.Time
+	Channel         string
+	Winner, Loser   string
+	WinPot, LosePot int64
+	Duration        int
+	Tier, Mode      string
+	Outcome         string
+	Reason          string `json:",omitempty"`
+}
+
+type matchPage struct {
+	Matches []historyMatch
+	// Next is the offset of the following page, if there is one
+	Next int `json:",omitempty"`
+}
+
+type winLoss struct {
+	Wins, Losses int
+}
+
+type charRecord struct {
+	Name string
+	winLoss
+	Opponent string `json:",omitempty"`
+	// HeadToHead is the record against Opponent alone
+	HeadToHead *winLoss `json:",omitempty"`
+	matchPage
+}
+
+// counts returns the scan destinations for the count query from charQueries
+func (rec *charRecord) counts() []interface{} {
+	dest := []interface{}{&rec.Wins, &rec.Losses}
+	if rec.Opponent != "" {
+		rec.HeadToHead = new(winLoss)
+		dest = append(dest, &rec.HeadToHead.Wins, &rec.HeadToHead.Losses)
+	}
+	return dest
+}
+
+type tierSummary struct {
+	Tier        string
+	Matches     int
+	Characters  int
+	AvgDuration float64
+	AvgPot      float64
+}
+
+// historyFilter holds the common filtering and pagination parameters
+type historyFilter struct {
+	Channel      string
+	Mode, Tier   string
+	Since, Until time.Time
+	Limit        int
+	Offset       int
+}
+
+func parseHistoryFilter(req *http.Request) (f historyFilter, err error) {
+	f.Channel = strings.ToLower(req.FormValue("channel"))
+	if f.Channel != "" && !strings.HasPrefix(f.Channel, "#") {
+		f.Channel = "#" + f.Channel
+	}
+	f.Mode = req.FormValue("mode")
+	f.Tier = req.FormValue("tier")
+	if f.Since, err = parseTimeParam(req.FormValue("since")); err != nil {
+		return f, fmt.Errorf("since: %s", err)
+	}
+	if f.Until, err = parseTimeParam(req.FormValue("until")); err != nil {
+		return f, fmt.Errorf("until: %s", err)
+	}
+	f.Limit = defaultPageSize
+	if v := req.FormValue("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit <= 0 {
+			return f, fmt.Errorf("invalid limit %q", v)
+		}
+		if f.Limit > maxPageSize {
+			f.Limit = maxPageSize
+		}
+	}
+	if v := req.FormValue("offset"); v != "" {
+		if f.Offset, err = strconv.Atoi(v); err != nil || f.Offset < 0 {
+			return f, fmt.Errorf("invalid offset %q", v)
+		}
+	}
+	return f, nil
+}
+
+func parseTimeParam(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", v)
+}
+
+// where builds a WHERE clause for the filter, appending to args
+func (f historyFilter) where(args []interface{}, extra ...string) (string, []interface{}) {
+	conds := extra
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if f.Channel != "" {
+		add("channel = $%d", f.Channel)
+	}
+	if f.Mode != "" {
+		add("mode = $%d", f.Mode)
+	}
+	if f.Tier != "" {
+		add("tier = $%d", f.Tier)
+	}
+	if !f.Since.IsZero() {
+		add("ts >= $%d", f.Since)
+	}
+	if !f.Until.IsZero() {
+		add("ts < $%d", f.Until)
+	}
+	if len(conds) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// matchesQuery selects a page of matches for the filter plus any extra
+// conditions, which may refer to the leading args
+func matchesQuery(f historyFilter, args []interface{}, extra ...string) (string, []interface{}) {
+	where, args := f.where(args, extra...)
+	q := "SELECT match_id, ts, channel, winner, loser, winpot, losepot, duration, tier, mode, outcome, coalesce(reason, '') FROM matches" + where +
+		fmt.Sprintf(" ORDER BY ts DESC LIMIT %d OFFSET %d", f.Limit+1, f.Offset)
+	return q, args
+}
+
+func scanMatches(rows rowScanner, f historyFilter) (page matchPage, err error) {
+	page.Matches = []historyMatch{}
+	for rows.Next() {
+		var m historyMatch
+		var id *string
+		if err = rows.Scan(&id, &m.Time, &m.Channel, &m.Winner, &m.Loser, &m.WinPot, &m.LosePot, &m.Duration, &m.Tier, &m.Mode, &m.Outcome, &m.Reason); err != nil {
+			return
+		}
+		if id != nil {
+			m.ID = *id
+		}
+		page.Matches = append(page.Matches, m)
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	if len(page.Matches) > f.Limit {
+		page.Matches = page.Matches[:f.Limit]
+		page.Next = f.Offset + f.Limit
+	}
+	return
+}
+
+// charQueries returns the queries for a character's win/loss counts and paid
+// matches, sharing the same args. With an opponent the counts also include the
+// head-to-head record and only matches between the two are listed.
+func charQueries(f historyFilter, name, opponent string) (countQ, matchQ string, args []interface{}) {
+	args = []interface{}{name}
+	counts := "count(*) FILTER (WHERE winner = $1), count(*) FILTER (WHERE loser = $1)"
+	cond := "(winner = $1 OR loser = $1)"
+	listCond := cond
+	if opponent != "" {
+		args = append(args, opponent)
+		counts += ", count(*) FILTER (WHERE winner = $1 AND loser = $2), count(*) FILTER (WHERE winner = $2 AND loser = $1)"
+		listCond = "((winner = $1 AND loser = $2) OR (winner = $2 AND loser = $1))"
+	}
+	where, _ := f.where(args, cond, "outcome = 'paid'")
+	countQ = "SELECT " + counts + " FROM matches" + where
+	matchQ, args = matchesQuery(f, args, listCond, "outcome = 'paid'")
+	return
+}
+
+// tiersQuery summarizes paid matches per tier. Each match is counted once
+// per participant so that distinct characters can be counted.
+func tiersQuery(f historyFilter) (string, []interface{}) {
+	where, args := f.where(nil, "outcome = 'paid'")
+	inner := "SELECT tier, %s AS name, duration, winpot + losepot AS pot FROM matches" + where
+	q := "SELECT tier, count(*) / 2, count(DISTINCT name), CAST(avg(duration) AS double precision), CAST(avg(pot) AS double precision) FROM (" +
+		fmt.Sprintf(inner, "winner") + " UNION ALL " + fmt.Sprintf(inner, "loser") +
+		") AS participants GROUP BY tier ORDER BY tier"
+	return q, args
+}
+
+func scanTiers(rows rowScanner) ([]tierSummary, error) {
+	summaries := []tierSummary{}
+	for rows.Next() {
+		var t tierSummary
+		if err := rows.Scan(&t.Tier, &t.Matches, &t.Characters, &t.AvgDuration, &t.AvgPot); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, t)
+	}
+	return summaries, rows.Err()
+}
+
+// viewMatches lists recent matches, newest first
+func (s *TokenServer) viewMatches(rw http.ResponseWriter, req *http.Request) {
+	f, err := parseHistoryFilter(req)
+	if err != nil {
+		http.Error(rw, err.Error(), 400)
+		return
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+	defer cancel()
+	page, err := db.Matches(ctx, f)
+	if err != nil {
+		historyError(rw, err)
+		return
+	}
+	writeJSON(rw, page)
+}
+
+// viewCharacter reports one character's record and paid matches. If vs is
+// given then the head-to-head record is added and only matches against that
+// opponent are listed.
+func (s *TokenServer) viewCharacter(rw http.ResponseWriter, req *http.Request) {
+	f, err := parseHistoryFilter(req)
+	if err != nil {
+		http.Error(rw, err.Error(), 400)
+		return
+	}
+	name := req.FormValue("name")
+	if name == "" {
+		http.Error(rw, "name is required", 400)
+		return
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+	defer cancel()
+	rec, err := db.CharRecord(ctx, f, name, req.FormValue("vs"))
+	if err != nil {
+		historyError(rw, err)
+		return
+	}
+	writeJSON(rw, rec)
+}
+
+// viewTiers summarizes paid matches in each tier
+func (s *TokenServer) viewTiers(rw http.ResponseWriter, req *http.Request) {
+	f, err := parseHistoryFilter(req)
+	if err != nil {
+		http.Error(rw, err.Error(), 400)
+		return
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+	defer cancel()
+	summaries, err := db.TierSummaries(ctx, f)
+	if err != nil {
+		historyError(rw, err)
+		return
+	}
+	writeJSON(rw, summaries)
+}
+
+func historyError(rw http.ResponseWriter, err error) {
+	log.Printf("error: querying match history: %s", err)
+	http.Error(rw, "query failed", 500)
+}
+
+func writeJSON(rw http.ResponseWriter, v interface{}) {
+	writeJSONStatus(rw, http.StatusOK, v)
+}
+
+func writeJSONStatus(rw http.ResponseWriter, status int, v interface{}) {
+	blob, err := json.Marshal(v)
+	if err != nil {
+		http.Error(rw, err.Error(), 500)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	rw.Write(blob)
+}
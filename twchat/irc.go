@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"fmt"
 	"log"
+	"math/rand"
 	"net"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -16,117 +18,82 @@ import (
 )
 
 const (
-	ircHost    = "irc.chat.twitch.tv"
-	ircPort    = "6697"
-	ircChannel = "#saltybet"
-	bot1Name   = "waifu4u"
-	bot2Name   = "saltybet"
+	ircHost = "irc.chat.twitch.tv"
+	ircPort = "6697"
+	tagsCap = "twitch.tv/tags"
 )
 
-var (
-	lineOpen   = regexp.MustCompile(`Bets are OPEN for (.*) vs (.*)! \((?:(.*) Tier|Requested by .*?)\)(?: \(.*\))? (?:\((.*)\) www.saltybet.com|(tournament) bracket.*)$`)
-	closedPart = `.*?(?:\(([^)]+)\) )?- \$(.*)`
-	tierPart   = `(?:.|None)`
-	lineClosed = regexp.MustCompile(`Bets are locked\. ` + closedPart + `, ` + closedPart)
-	linePaid   = regexp.MustCompile(`.* wins! Payouts to Team (.*)\. (.*)!`)
-	lineMode   = regexp.MustCompile(`^(Tournament|Matchmaking|Exhibitions) will start shortly`)
-	lineIgnore = regexp.MustCompile(`^(wtfSalt |wtfVeku Note:|Current pot|Current stage|Current odds|Download WAIFU Wars|.* by.*, .* by.*|` + tierPart + `(?: / ` + tierPart + `)? Tier$|The current game mode is:|The current tournament bracket|Palettes of previous match:|.* vs .* was requested by|Join the official Salty Bet)`)
+// Lifecycle of the current match
+const (
+	phaseOpen   = "open"
+	phaseLocked = "locked"
+	phasePaid   = "paid"
+
+	// how long a paid match stays current
+	paidLinger = 30 * time.Second
 )
 
 type matchRecord struct {
-	Name1, Name2 string
-	Tier, Mode   string
-	Pot1, Pot2   int64     `json:"-"`
-	TwoWins      bool      `json:"-"`
-	Start, Stop  time.Time `json:"-"`
+	ID               string
+	Channel          string
+	Name1, Name2     string
+	Tier, Mode       string
+	Phase            string
+	Pot1, Pot2       int64
+	Streak1, Streak2 int64
+	TwoWins          bool
+	Start, Stop      time.Time
 }
 
-func runIRC(ts oauth2.TokenSource) error {
+// runIRC connects to chat and records matches in each watched channel until
+// disconnected. If ts is nil then an anonymous read-only login is used.
+func runIRC(ts oauth2.TokenSource, watchers map[string]*channelWatcher) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	t, err := ts.Token()
-	if err != nil {
-		return fmt.Errorf("can't connect to IRC: %s", err)
-	}
 	ic := goirc.NewConfig("thorium", "thorium", "thorium saltbot") // nick is ignored
 	ic.Server = net.JoinHostPort(ircHost, ircPort)
 	ic.SSL = true
 	ic.SSLConfig = &tls.Config{ServerName: ircHost}
-	ic.Pass = "oauth:" + t.AccessToken
+	if ts != nil {
+		t, err := ts.Token()
+		if err != nil {
+			return fmt.Errorf("can't connect to IRC: %s", err)
+		}
+		ic.Pass = "oauth:" + t.AccessToken
+	} else {
+		// twitch allows reading chat without a password using this nick
+		ic.Me.Nick = fmt.Sprintf("justinfan%d", 10000+rand.Intn(90000))
+	}
 
 	cl := goirc.Client(ic)
 	cl.HandleFunc(goirc.CONNECTED, func(conn *goirc.Conn, line *goirc.Line) {
 		log.Println("connected")
-		conn.Join(ircChannel)
+		health.SetConnected(true)
+		conn.Cap("REQ", tagsCap)
+		for channel := range watchers {
+			conn.Join(channel)
+		}
+	})
+	cl.HandleFunc(goirc.CAP, func(conn *goirc.Conn, line *goirc.Line) {
+		if len(line.Args) >= 2 && line.Args[1] == "NAK" {
+			log.Printf("warning: server refused capability %q, timing will use local clock", line.Text())
+		}
 	})
 	cl.HandleFunc(goirc.DISCONNECTED, func(conn *goirc.Conn, line *goirc.Line) {
+		health.SetConnected(false)
 		cancel()
 	})
-	var status string
-	var mr matchRecord
 	cl.HandleFunc(goirc.PRIVMSG, func(conn *goirc.Conn, line *goirc.Line) {
-		if line.Nick != bot1Name && line.Nick != bot2Name {
+		w := watchers[strings.ToLower(line.Target())]
+		if w == nil || !w.bots[strings.ToLower(line.Nick)] {
 			return
 		}
-		text := line.Text()
-		if m := lineOpen.FindStringSubmatch(text); m != nil {
-			mr = matchRecord{
-				Name1: m[1],
-				Name2: m[2],
-				Tier:  m[3],
-				Mode:  m[4],
-			}
-			if mr.Mode == "" && m[5] == "tournament" {
-				mr.Mode = "tournament"
-			}
-			log.Printf("bets open: red=%s blue=%s tier=%s mode=%s", m[1], m[2], m[3], mr.Mode)
-			status = "open"
-			if err := setCurrentMatch(mr); err != nil {
-				log.Printf("error: setting current match: %s", err)
-			}
-			currentMatchNotify(mr)
-		} else if m := lineClosed.FindStringSubmatch(text); m != nil {
-			log.Printf("bets locked: streakRed=%s potRed=%s streakBlue=%s potBlue=%s", m[1], m[2], m[3], m[4])
-			if status == "open" {
-				mr.Start = time.Now()
-				mr.Pot1, _ = strconv.ParseInt(strings.Replace(m[2], ",", "", -1), 10, 64)
-				mr.Pot2, _ = strconv.ParseInt(strings.Replace(m[4], ",", "", -1), 10, 64)
-				status = "locked"
-			}
-		} else if m := lineMode.FindStringSubmatch(text); m != nil {
-			log.Printf("match over: mode=%s", strings.ToLower(m[1]))
-			if status == "locked" {
-				// mode switch but no match result yet
-				mr.Stop = time.Now()
-				if err := clearCurrentMatch(); err != nil {
-					log.Printf("error: setting current match: %s", err)
-				}
-				currentMatchNotify(matchRecord{})
-			}
-		} else if m := linePaid.FindStringSubmatch(text); m != nil {
-			log.Printf("match over: winner=%s remaining=%s", m[1], m[2])
-			if status == "locked" {
-				if mr.Stop.IsZero() {
-					mr.Stop = time.Now()
-				}
-				switch m[1] {
-				case "Red":
-					mr.TwoWins = false
-				case "Blue":
-					mr.TwoWins = true
-				default:
-					return
-				}
-				recordMatch(mr)
-				if err := clearCurrentMatch(); err != nil {
-					log.Printf("error: setting current match: %s", err)
-				}
-				currentMatchNotify(matchRecord{})
-			}
-			status = ""
-		} else if !lineIgnore.MatchString(text) {
-			log.Printf("%q", text)
+		if seenMessages.Seen(line.Tags["id"]) {
+			return
 		}
+		sent, server := lineTime(line)
+		health.BotLine(sent)
+		w.handle(sent, server, line.Nick, line.Text())
 	})
 
 	log.Println("attempting connection to", ic.Server)
@@ -135,5 +102,61 @@ func runIRC(ts oauth2.TokenSource) error {
 	}
 	<-ctx.Done()
 	log.Printf("warning: IRC disconnected")
+	for _, w := range watchers {
+		w.disconnected()
+	}
 	return nil
 }
+
+// matchID derives a stable identifier for a match from the channel, the
+// fighters, the tier and the time bets locked, so that replaying the same chat
+// always produces the same ID
+func matchID(channel, name1, name2, tier string, locked time.Time) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%d", channel, name1, name2, tier, locked.Unix())
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// lineTime returns when the server says a message was sent, and true. If tags
+// are not available it falls back to when it was received, and false.
+func lineTime(line *goirc.Line) (time.Time, bool) {
+	if ms, err := strconv.ParseInt(line.Tags["tmi-sent-ts"], 10, 64); err == nil && ms > 0 {
+		return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond)), true
+	}
+	if !line.Time.IsZero() {
+		return line.Time, false
+	}
+	return time.Now(), false
+}
+
+var seenMessages = newMsgDedup(256)
+
+// msgDedup remembers the most recent message IDs so that a message delivered
+// twice is only processed once.
+type msgDedup struct {
+	ids  map[string]bool
+	ring []string
+	pos  int
+}
+
+func newMsgDedup(size int) *msgDedup {
+	return &msgDedup{
+		ids:  make(map[string]bool, size),
+		ring: make([]string, size),
+	}
+}
+
+// Seen records id and reports whether it was already recorded. Empty IDs are
+// never considered seen.
+func (d *msgDedup) Seen(id string) bool {
+	if id == "" {
+		return false
+	} else if d.ids[id] {
+		return true
+	}
+	delete(d.ids, d.ring[d.pos])
+	d.ring[d.pos] = id
+	d.ids[id] = true
+	d.pos = (d.pos + 1) % len(d.ring)
+	return false
+}
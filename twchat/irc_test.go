@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMsgDedup(t *testing.T) {
+	d := newMsgDedup(3)
+	for i, c := range []struct {
+		id   string
+		seen bool
+	}{
+		{"a", false},
+		{"a", true},
+		{"", false},
+		{"", false},
+		{"b", false},
+		{"c", false},
+		{"a", true},
+		// pushes a out
+		{"d", false},
+		{"a", false},
+		{"c", true},
+	} {
+		if got := d.Seen(c.id); got != c.seen {
+			t.Errorf("step %d: Seen(%q) = %v, want %v", i, c.id, got, c.seen)
+		}
+	}
+	if len(d.ids) > 3 {
+		t.Errorf("remembering %d IDs, want at most 3", len(d.ids))
+	}
+}
+
+func TestMatchID(t *testing.T) {
+	locked := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	base := matchID("#saltybet", "Red", "Blue", "A", locked)
+	if len(base) != 32 {
+		t.Fatalf("matchID = %q, want 32 hex digits", base)
+	}
+	for _, c := range []struct {
+		name                        string
+		channel, name1, name2, tier string
+		locked                      time.Time
+		same                        bool
+	}{
+		{"replayed", "#saltybet", "Red", "Blue", "A", locked, true},
+		{"same second", "#saltybet", "Red", "Blue", "A", locked.Add(999 * time.Millisecond), true},
+		{"other timezone", "#saltybet", "Red", "Blue", "A", locked.In(time.FixedZone("x", 3600)), true},
+		{"next second", "#saltybet", "Red", "Blue", "A", locked.Add(time.Second), false},
+		{"other channel", "#other", "Red", "Blue", "A", locked, false},
+		{"sides swapped", "#saltybet", "Blue", "Red", "A", locked, false},
+		{"other tier", "#saltybet", "Red", "Blue", "S", locked, false},
+		// the separator keeps fields from running together
+		{"shifted names", "#saltybet", "Re", "dBlue", "A", locked, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			id := matchID(c.channel, c.name1, c.name2, c.tier, c.locked)
+			if (id == base) != c.same {
+				t.Errorf("matchID = %s, base %s, want same=%v", id, base, c.same)
+			}
+		})
+	}
+}
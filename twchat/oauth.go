@@ -3,11 +3,16 @@ package main
 import (
 	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"html/template"
 	"log"
 	"net/http"
+	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -21,14 +26,41 @@ const (
 	minRetry      = 1 * time.Second
 	maxRetry      = 60 * time.Second
 	backoffFactor = 3
+
+	stateExpiry = 10 * time.Minute
+	validateURL = "https://id.twitch.tv/oauth2/validate"
 )
 
 func main() {
 	// configure
 	viper.AutomaticEnv()
+	if cfgFile := viper.GetString("config"); cfgFile != "" {
+		viper.SetConfigFile(cfgFile)
+		if err := viper.ReadInConfig(); err != nil {
+			log.Fatalln("error: reading config:", err)
+		}
+	}
 	if err := connectDB(); err != nil {
 		log.Fatalln("error: connect to db:", err)
 	}
+	if len(os.Args) > 1 {
+		var err error
+		switch os.Args[1] {
+		case "migrate":
+			err = db.Migrate()
+		case "unparsed":
+			err = reportUnparsed(os.Args[2:])
+		default:
+			log.Fatalln("usage: twchat [migrate | unparsed [limit]]")
+		}
+		if err != nil {
+			log.Fatalln("error:", err)
+		}
+		return
+	}
+	if err := db.CheckSchema(); err != nil {
+		log.Fatalln("error:", err)
+	}
 	conf := &oauth2.Config{
 		ClientID:     viper.GetString("client_id"),
 		ClientSecret: viper.GetString("client_secret"),
@@ -36,32 +68,72 @@ func main() {
 		Endpoint:     twitch.Endpoint,
 		Scopes:       []string{"chat:read"},
 	}
+	channels, err := loadChannels()
+	if err != nil {
+		log.Fatalln("error: loading channels:", err)
+	}
+	watchers, err := newWatchers(channels)
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
 	newToken := make(chan struct{})
 	s := &TokenServer{
 		Oauth:    conf,
 		NewToken: newToken,
+		Allowed:  make(map[string]bool),
+		Secret:   viper.GetString("login_secret"),
 	}
-	http.HandleFunc("/healthz", s.viewHealth)
-	http.HandleFunc("/current", s.viewCurrent)
-	http.HandleFunc("/", s.viewCallback)
-	var good bool
-	t, err := getToken()
-	if err != nil {
-		log.Fatalln("error: failed to retrieve token:", err)
-	} else if t != nil {
-		s.source = oauth2.ReuseTokenSource(nil, s.Oauth.TokenSource(context.Background(), t))
-		if _, err := s.Token(); err == nil {
-			good = true
+	// ALLOWED_USERS from the environment may be separated by spaces or commas
+	for _, item := range viper.GetStringSlice("allowed_users") {
+		for _, name := range strings.Split(item, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				s.Allowed[strings.ToLower(name)] = true
+			}
 		}
 	}
-	go http.ListenAndServe(viper.GetString("listen"), nil)
-	if good {
-		log.Println("loaded token from db")
+	http.HandleFunc("/healthz", s.viewHealth)
+	http.HandleFunc("/current", s.viewCurrent)
+	http.HandleFunc("/current/events", s.viewEvents)
+	http.HandleFunc("/current/ws", s.viewSocket)
+	http.HandleFunc("/matches", s.viewMatches)
+	http.HandleFunc("/character", s.viewCharacter)
+	http.HandleFunc("/tiers", s.viewTiers)
+	var ts oauth2.TokenSource
+	s.Anonymous = viper.GetBool("anonymous")
+	if s.Anonymous {
+		log.Println("using anonymous read-only login")
+		go http.ListenAndServe(viper.GetString("listen"), nil)
 	} else {
-		log.Println("saved token not available, please log in at", conf.RedirectURL)
-		<-newToken
+		if len(s.Allowed) == 0 {
+			log.Fatalln("error: allowed_users is empty, so no twitch login would be accepted")
+		}
+		http.HandleFunc("/", s.viewCallback)
+		if err := loadTokenKeys(); err != nil {
+			log.Fatalln("error:", err)
+		}
+		if err := rotateTokens(); err != nil {
+			log.Fatalln("error: re-encrypting stored tokens:", err)
+		}
+		var good bool
+		t, err := getToken()
+		if err != nil {
+			log.Fatalln("error: failed to retrieve token:", err)
+		} else if t != nil {
+			s.source = oauth2.ReuseTokenSource(nil, s.Oauth.TokenSource(context.Background(), t))
+			if _, err := s.Token(); err == nil {
+				good = true
+			}
+		}
+		go http.ListenAndServe(viper.GetString("listen"), nil)
+		if good {
+			log.Println("loaded token from db")
+		} else {
+			log.Println("saved token not available, please log in at", conf.RedirectURL)
+			<-newToken
+		}
+		go s.keepAlive()
+		ts = s
 	}
-	go s.keepAlive()
 
 	delay := minRetry
 	timer := time.NewTimer(delay)
@@ -71,7 +143,7 @@ func main() {
 		case <-timer.C:
 		}
 		start := time.Now()
-		if err := runIRC(s); err != nil {
+		if err := runIRC(ts, watchers); err != nil {
 			log.Printf("error: %s", err)
 		}
 		if time.Since(start) > delay*3 {
@@ -88,34 +160,32 @@ func main() {
 
 type TokenServer struct {
 	Oauth    *oauth2.Config
-	Home     string
 	NewToken chan<- struct{}
+	// Allowed holds the twitch logins permitted to supply the bot's token
+	Allowed map[string]bool
+	// Secret, if set, must be passed to the login page to start a login
+	Secret string
+	// Anonymous is set if chat is read without a token
+	Anonymous bool
 
 	source oauth2.TokenSource
-	state  string
+	states map[string]time.Time
 	mu     sync.Mutex
 }
 
 func (s *TokenServer) viewCallback(rw http.ResponseWriter, req *http.Request) {
+	if e := req.FormValue("error"); e != "" {
+		http.Error(rw, "login failed: "+e, 400)
+		return
+	}
 	state := req.FormValue("state")
 	code := req.FormValue("code")
 	if state == "" || code == "" {
-		d := make([]byte, 8)
-		rand.Reader.Read(d)
-		state := hex.EncodeToString(d)
-		authURL := s.Oauth.AuthCodeURL(state)
-		s.mu.Lock()
-		s.state = state
-		s.mu.Unlock()
-		fmt.Fprintf(rw, `<!DOCTYPE html>
-<html><body><script type="text/javascript">
-location.href = "%s";
-</script><a href="%s">login to twitch</a></body></html>
-`, authURL, authURL)
+		s.startLogin(rw, req)
 		return
 	}
-	if state != req.FormValue("state") {
-		http.Error(rw, "wrong oauth state", 400)
+	if !s.consumeState(state) {
+		http.Error(rw, "wrong or expired oauth state", 400)
 		return
 	}
 	t, err := s.Oauth.Exchange(req.Context(), code)
@@ -123,8 +193,17 @@ location.href = "%s";
 		http.Error(rw, err.Error(), 500)
 		return
 	}
+	login, err := validateToken(req.Context(), t)
+	if err != nil {
+		http.Error(rw, err.Error(), 500)
+		return
+	} else if !s.Allowed[strings.ToLower(login)] {
+		log.Printf("warning: rejected login from twitch user %q", login)
+		http.Error(rw, "user is not allowed to log in", 403)
+		return
+	}
+	log.Printf("new token from twitch user %q", login)
 	s.mu.Lock()
-	s.state = ""
 	s.source = oauth2.ReuseTokenSource(nil, s.Oauth.TokenSource(context.Background(), t))
 	s.mu.Unlock()
 	s.Token() // trigger token save
@@ -136,20 +215,80 @@ location.href = "%s";
 	fmt.Fprintf(rw, "login complete")
 }
 
-func (s *TokenServer) viewHealth(rw http.ResponseWriter, req *http.Request) {
-	ctx, cancel := context.WithTimeout(req.Context(), 5*time.Second)
-	defer cancel()
-	conn, err := db.Acquire()
-	if err == nil {
-		defer db.Release(conn)
-		err = conn.Ping(ctx)
-		if err == nil {
-			fmt.Fprintln(rw, "OK")
-			return
+// startLogin redirects to twitch with a newly issued state
+func (s *TokenServer) startLogin(rw http.ResponseWriter, req *http.Request) {
+	if s.Secret != "" && subtle.ConstantTimeCompare([]byte(req.FormValue("secret")), []byte(s.Secret)) != 1 {
+		http.Error(rw, "forbidden", 403)
+		return
+	}
+	d := make([]byte, 16)
+	if _, err := rand.Read(d); err != nil {
+		http.Error(rw, err.Error(), 500)
+		return
+	}
+	state := hex.EncodeToString(d)
+	authURL := s.Oauth.AuthCodeURL(state)
+	now := time.Now()
+	s.mu.Lock()
+	if s.states == nil {
+		s.states = make(map[string]time.Time)
+	}
+	for st, expires := range s.states {
+		if now.After(expires) {
+			delete(s.states, st)
 		}
 	}
-	log.Printf("error: in health check:", err)
-	http.Error(rw, err.Error(), 500)
+	s.states[state] = now.Add(stateExpiry)
+	s.mu.Unlock()
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	loginPage.Execute(rw, authURL)
+}
+
+// loginPage is rendered with html/template so the URL is escaped for the
+// script and the link separately
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html><body><script type="text/javascript">
+location.href = {{.}};
+</script><a href="{{.}}">login to twitch</a></body></html>
+`))
+
+// consumeState reports whether state was issued by this server and has not
+// expired. Each state can only be used once.
+func (s *TokenServer) consumeState(state string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expires, ok := s.states[state]
+	if !ok {
+		return false
+	}
+	delete(s.states, state)
+	return time.Now().Before(expires)
+}
+
+// validateToken returns the twitch login that owns the token
+func validateToken(ctx context.Context, t *oauth2.Token) (string, error) {
+	req, err := http.NewRequest("GET", validateURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "OAuth "+t.AccessToken)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("validating token: HTTP %s", resp.Status)
+	}
+	var info struct {
+		Login string `json:"login"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return "", fmt.Errorf("validating token: %s", err)
+	} else if info.Login == "" {
+		return "", errors.New("validating token: no login in response")
+	}
+	return info.Login, nil
 }
 
 func (s *TokenServer) Token() (*oauth2.Token, error) {
@@ -161,8 +300,10 @@ func (s *TokenServer) Token() (*oauth2.Token, error) {
 	}
 	t, err := ts.Token()
 	if err != nil {
+		health.TokenRefreshed(time.Time{}, err)
 		return nil, err
 	}
+	health.TokenRefreshed(t.Expiry, nil)
 	if err := putToken(t); err != nil {
 		log.Printf("error: failed to persist token: %s", err)
 	}
@@ -175,7 +316,7 @@ func (s *TokenServer) keepAlive() {
 		<-t.C
 		if _, err := s.Token(); err != nil {
 			log.Printf("error: token refresh failed: %s", err)
-			log.Printf("Login page:", s.Home)
+			log.Printf("login page: %s", s.Oauth.RedirectURL)
 		}
 	}
 }
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+
+	"github.com/jackc/pgx"
+	"github.com/mtharp/thorium/schema"
+)
+
+// pgStore keeps everything in Postgres
+type pgStore struct {
+	*pgx.ConnPool
+}
+
+func connectPG() (*pgStore, error) {
+	cfg, err := pgx.ParseEnvLibpq()
+	if err != nil {
+		return nil, err
+	}
+	pool, err := pgx.NewConnPool(pgx.ConnPoolConfig{ConnConfig: cfg})
+	if err != nil {
+		return nil, err
+	}
+	return &pgStore{pool}, nil
+}
+
+func (db *pgStore) Ping(ctx context.Context) error {
+	conn, err := db.Acquire()
+	if err != nil {
+		return err
+	}
+	defer db.Release(conn)
+	return conn.Ping(ctx)
+}
+
+func (db *pgStore) CheckSchema() error {
+	return schema.Check(db.ConnPool)
+}
+
+func (db *pgStore) Migrate() error {
+	return schema.Migrate(db.ConnPool)
+}
+
+func (db *pgStore) InsertMatch(ctx context.Context, m storedMatch) (bool, error) {
+	tag, err := db.ExecEx(ctx, "INSERT INTO matches (match_id, ts, winner, loser, winpot, losepot, duration, tier, mode, outcome, reason, channel) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) ON CONFLICT (match_id) DO NOTHING", nil,
+		m.ID, m.Time, m.Winner, m.Loser, m.WinPot, m.LosePot, m.Duration, m.Tier, m.Mode, m.Outcome, nullString(m.Reason), m.Channel)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() != 0, nil
+}
+
+func (db *pgStore) SetCurrentMatch(ctx context.Context, rec matchRecord) error {
+	txn, err := db.BeginEx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+	_, err = txn.ExecEx(ctx, "DELETE FROM current_match WHERE channel = $1", nil, rec.Channel)
+	if err != nil {
+		return err
+	}
+	_, err = txn.ExecEx(ctx, "INSERT INTO current_match (match_id, channel, p1, p2, tier, mode) VALUES ($1, $2, $3, $4, $5, $6)", nil, rec.ID, rec.Channel, rec.Name1, rec.Name2, rec.Tier, rec.Mode)
+	if err != nil {
+		return err
+	}
+	txn.ExecEx(ctx, "NOTIFY current_match", nil)
+	return txn.CommitEx(ctx)
+}
+
+func (db *pgStore) ClearCurrentMatch(ctx context.Context, channel string) error {
+	txn, err := db.BeginEx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+	_, err = txn.ExecEx(ctx, "DELETE FROM current_match WHERE channel = $1", nil, channel)
+	if err != nil {
+		return err
+	}
+	txn.ExecEx(ctx, "NOTIFY current_match", nil)
+	return txn.CommitEx(ctx)
+}
+
+func (db *pgStore) GetToken(ctx context.Context, name string) ([]byte, error) {
+	var blob []byte
+	err := db.QueryRowEx(ctx, "SELECT token FROM tokens WHERE name = $1", nil, name).Scan(&blob)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return blob, err
+}
+
+func (db *pgStore) PutToken(ctx context.Context, name string, blob []byte) error {
+	_, err := db.ExecEx(ctx, "INSERT INTO tokens (name, token) VALUES ($1, $2) ON CONFLICT (name) DO UPDATE SET token = $2", nil, name, blob)
+	return err
+}
+
+func (db *pgStore) RewriteTokens(ctx context.Context, fn func(name string, blob []byte) ([]byte, error)) error {
+	txn, err := db.BeginEx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+	rows, err := txn.QueryEx(ctx, "SELECT name, token FROM tokens FOR UPDATE", nil)
+	if err != nil {
+		return err
+	}
+	updated, err := rewriteRows(rows, fn)
+	rows.Close()
+	if err != nil {
+		return err
+	}
+	for name, blob := range updated {
+		if _, err := txn.ExecEx(ctx, "UPDATE tokens SET token = $1 WHERE name = $2", nil, blob, name); err != nil {
+			return err
+		}
+	}
+	return txn.CommitEx(ctx)
+}
+
+func (db *pgStore) RecordUnparsed(ctx context.Context, line unparsedLine) error {
+	_, err := db.ExecEx(ctx, upsertUnparsed, nil, line.Channel, line.Template, line.LastSeen, line.Nick, line.Text)
+	return err
+}
+
+func (db *pgStore) TopUnparsed(ctx context.Context, limit int) ([]unparsedLine, error) {
+	rows, err := db.QueryEx(ctx, selectUnparsed, nil, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanUnparsed(rows)
+}
+
+func (db *pgStore) Matches(ctx context.Context, f historyFilter) (matchPage, error) {
+	q, args := matchesQuery(f, nil)
+	rows, err := db.QueryEx(ctx, q, nil, args...)
+	if err != nil {
+		return matchPage{}, err
+	}
+	defer rows.Close()
+	return scanMatches(rows, f)
+}
+
+func (db *pgStore) CharRecord(ctx context.Context, f historyFilter, name, opponent string) (rec charRecord, err error) {
+	rec.Name, rec.Opponent = name, opponent
+	countQ, matchQ, args := charQueries(f, name, opponent)
+	if err = db.QueryRowEx(ctx, countQ, nil, args...).Scan(rec.counts()...); err != nil {
+		return
+	}
+	rows, err := db.QueryEx(ctx, matchQ, nil, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	rec.matchPage, err = scanMatches(rows, f)
+	return
+}
+
+func (db *pgStore) TierSummaries(ctx context.Context, f historyFilter) ([]tierSummary, error) {
+	q, args := tiersQuery(f)
+	rows, err := db.QueryEx(ctx, q, nil, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanTiers(rows)
+}
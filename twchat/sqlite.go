@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/mtharp/thorium/schema"
+)
+
+// sqliteStore keeps everything in an embedded database file for local
+// development. Times are stored in UTC so they compare correctly as text.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+func openSQLite(path string) (*sqliteStore, error) {
+	sdb, err := schema.OpenSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteStore{sdb}, nil
+}
+
+// args converts times to UTC
+func (db *sqliteStore) args(args []interface{}) []interface{} {
+	for i, arg := range args {
+		if t, ok := arg.(time.Time); ok {
+			args[i] = t.UTC()
+		}
+	}
+	return args
+}
+
+func (db *sqliteStore) exec(ctx context.Context, q string, args ...interface{}) (sql.Result, error) {
+	return db.db.ExecContext(ctx, schema.Rebind(q), db.args(args)...)
+}
+
+func (db *sqliteStore) query(ctx context.Context, q string, args ...interface{}) (*sql.Rows, error) {
+	return db.db.QueryContext(ctx, schema.Rebind(q), db.args(args)...)
+}
+
+func (db *sqliteStore) Ping(ctx context.Context) error {
+	return db.db.PingContext(ctx)
+}
+
+func (db *sqliteStore) CheckSchema() error {
+	return schema.CheckSQLite(db.db)
+}
+
+func (db *sqliteStore) Migrate() error {
+	return schema.MigrateSQLite(db.db)
+}
+
+func (db *sqliteStore) InsertMatch(ctx context.Context, m storedMatch) (bool, error) {
+	res, err := db.exec(ctx, "INSERT INTO matches (match_id, ts, winner, loser, winpot, losepot, duration, tier, mode, outcome, reason, channel) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) ON CONFLICT (match_id) DO NOTHING",
+		m.ID, m.Time, m.Winner, m.Loser, m.WinPot, m.LosePot, m.Duration, m.Tier, m.Mode, m.Outcome, nullString(m.Reason), m.Channel)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n != 0, err
+}
+
+func (db *sqliteStore) SetCurrentMatch(ctx context.Context, rec matchRecord) error {
+	_, err := db.exec(ctx, "INSERT OR REPLACE INTO current_match (match_id, channel, p1, p2, tier, mode) VALUES ($1, $2, $3, $4, $5, $6)", rec.ID, rec.Channel, rec.Name1, rec.Name2, rec.Tier, rec.Mode)
+	return err
+}
+
+func (db *sqliteStore) ClearCurrentMatch(ctx context.Context, channel string) error {
+	_, err := db.exec(ctx, "DELETE FROM current_match WHERE channel = $1", channel)
+	return err
+}
+
+func (db *sqliteStore) GetToken(ctx context.Context, name string) ([]byte, error) {
+	var blob []byte
+	err := db.db.QueryRowContext(ctx, "SELECT token FROM tokens WHERE name = ?1", name).Scan(&blob)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return blob, err
+}
+
+func (db *sqliteStore) PutToken(ctx context.Context, name string, blob []byte) error {
+	_, err := db.exec(ctx, "INSERT INTO tokens (name, token) VALUES ($1, $2) ON CONFLICT (name) DO UPDATE SET token = $2", name, blob)
+	return err
+}
+
+func (db *sqliteStore) RewriteTokens(ctx context.Context, fn func(name string, blob []byte) ([]byte, error)) error {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, "SELECT name, token FROM tokens")
+	if err != nil {
+		return err
+	}
+	updated, err := rewriteRows(rows, fn)
+	rows.Close()
+	if err != nil {
+		return err
+	}
+	for name, blob := range updated {
+		if _, err := tx.ExecContext(ctx, "UPDATE tokens SET token = ?1 WHERE name = ?2", blob, name); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *sqliteStore) RecordUnparsed(ctx context.Context, line unparsedLine) error {
+	_, err := db.exec(ctx, upsertUnparsed, line.Channel, line.Template, line.LastSeen, line.Nick, line.Text)
+	return err
+}
+
+func (db *sqliteStore) TopUnparsed(ctx context.Context, limit int) ([]unparsedLine, error) {
+	rows, err := db.query(ctx, selectUnparsed, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanUnparsed(rows)
+}
+
+func (db *sqliteStore) Matches(ctx context.Context, f historyFilter) (matchPage, error) {
+	q, args := matchesQuery(f, nil)
+	rows, err := db.query(ctx, q, args...)
+	if err != nil {
+		return matchPage{}, err
+	}
+	defer rows.Close()
+	return scanMatches(rows, f)
+}
+
+func (db *sqliteStore) CharRecord(ctx context.Context, f historyFilter, name, opponent string) (rec charRecord, err error) {
+	rec.Name, rec.Opponent = name, opponent
+	countQ, matchQ, args := charQueries(f, name, opponent)
+	if err = db.db.QueryRowContext(ctx, schema.Rebind(countQ), db.args(args)...).Scan(rec.counts()...); err != nil {
+		return
+	}
+	rows, err := db.query(ctx, matchQ, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	rec.matchPage, err = scanMatches(rows, f)
+	return
+}
+
+func (db *sqliteStore) TierSummaries(ctx context.Context, f historyFilter) ([]tierSummary, error) {
+	q, args := tiersQuery(f)
+	rows, err := db.query(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanTiers(rows)
+}
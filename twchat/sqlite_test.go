@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestStore(t *testing.T) *sqliteStore {
+	db, err := openSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.db.Close() })
+	if err := db.CheckSchema(); err == nil {
+		t.Error("CheckSchema passed before migrating")
+	}
+	if err := db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CheckSchema(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// storeMatches inserts a small history, one minute apart starting at base
+func storeMatches(t *testing.T, db *sqliteStore, base time.Time) {
+	ctx := context.Background()
+	for i, m := range []storedMatch{
+		{ID: "1", Winner: "Ryu", Loser: "Ken", Tier: "A", Mode: "matchmaking", Outcome: "paid"},
+		{ID: "2", Winner: "Ken", Loser: "Ryu", Tier: "A", Mode: "matchmaking", Outcome: "paid"},
+		{ID: "3", Winner: "Ryu", Loser: "Guile", Tier: "B", Mode: "tournament", Outcome: "paid"},
+		{ID: "4", Winner: "Ryu", Loser: "Ken", Tier: "A", Mode: "matchmaking", Outcome: "void", Reason: voidNoPayout},
+		{ID: "5", Winner: "Ryu", Loser: "Ken", Tier: "A", Mode: "matchmaking", Outcome: "paid", Channel: "#other"},
+	} {
+		if m.Channel == "" {
+			m.Channel = "#saltybet"
+		}
+		m.Time = base.Add(time.Duration(i) * time.Minute)
+		m.WinPot, m.LosePot, m.Duration = 100, 50, 60*(i+1)
+		if inserted, err := db.InsertMatch(ctx, m); err != nil || !inserted {
+			t.Fatalf("InsertMatch(%s) = %v, %v", m.ID, inserted, err)
+		}
+	}
+	if inserted, err := db.InsertMatch(ctx, storedMatch{ID: "1", Time: base, Winner: "x", Loser: "y", Channel: "#saltybet", Outcome: "paid"}); err != nil || inserted {
+		t.Errorf("InsertMatch with a repeated ID = %v, %v", inserted, err)
+	}
+}
+
+func matchIDs(page matchPage) (ids []string) {
+	for _, m := range page.Matches {
+		ids = append(ids, m.ID)
+	}
+	return
+}
+
+func TestSQLiteHistory(t *testing.T) {
+	db := openTestStore(t)
+	ctx := context.Background()
+	base := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	storeMatches(t, db, base)
+
+	page, err := db.Matches(ctx, historyFilter{Channel: "#saltybet", Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := matchIDs(page); len(ids) != 2 || ids[0] != "4" || ids[1] != "3" || page.Next != 2 {
+		t.Errorf("first page = %v next %d", ids, page.Next)
+	}
+	if m := page.Matches[0]; m.Outcome != "void" || m.Reason != voidNoPayout || !m.Time.Equal(base.Add(3*time.Minute)) {
+		t.Errorf("void match = %+v", m)
+	}
+	// times are bound as $N parameters and compared as text
+	page, err = db.Matches(ctx, historyFilter{Since: base.Add(time.Minute), Until: base.Add(3 * time.Minute), Tier: "A", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := matchIDs(page); len(ids) != 1 || ids[0] != "2" || page.Next != 0 {
+		t.Errorf("filtered page = %v next %d", ids, page.Next)
+	}
+
+	rec, err := db.CharRecord(ctx, historyFilter{Channel: "#saltybet", Limit: 10}, "Ryu", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Wins != 2 || rec.Losses != 1 || rec.HeadToHead != nil || len(rec.Matches) != 3 {
+		t.Errorf("record = %d-%d, %d matches", rec.Wins, rec.Losses, len(rec.Matches))
+	}
+	rec, err = db.CharRecord(ctx, historyFilter{Channel: "#saltybet", Limit: 10}, "Ryu", "Ken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Wins != 2 || rec.Losses != 1 || rec.HeadToHead == nil || rec.HeadToHead.Wins != 1 || rec.HeadToHead.Losses != 1 {
+		t.Errorf("record vs Ken = %d-%d, head to head %+v", rec.Wins, rec.Losses, rec.HeadToHead)
+	}
+	if ids := matchIDs(rec.matchPage); len(ids) != 2 || ids[0] != "2" || ids[1] != "1" {
+		t.Errorf("matches vs Ken = %v", ids)
+	}
+
+	tiers, err := db.TierSummaries(ctx, historyFilter{Channel: "#saltybet"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []tierSummary{
+		{Tier: "A", Matches: 2, Characters: 2, AvgDuration: 90, AvgPot: 150},
+		{Tier: "B", Matches: 1, Characters: 2, AvgDuration: 180, AvgPot: 150},
+	}
+	if len(tiers) != len(want) {
+		t.Fatalf("tiers = %+v", tiers)
+	}
+	for i := range want {
+		if tiers[i] != want[i] {
+			t.Errorf("tier %d = %+v, want %+v", i, tiers[i], want[i])
+		}
+	}
+}
+
+func TestSQLiteCurrentMatch(t *testing.T) {
+	db := openTestStore(t)
+	ctx := context.Background()
+	current := func() (id string) {
+		t.Helper()
+		err := db.db.QueryRow("SELECT coalesce(max(match_id), '') FROM current_match WHERE channel = '#saltybet'").Scan(&id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	rec := matchRecord{ID: "1", Channel: "#saltybet", Name1: "Ryu", Name2: "Ken", Tier: "A", Mode: "matchmaking"}
+	if err := db.SetCurrentMatch(ctx, rec); err != nil {
+		t.Fatal(err)
+	}
+	rec.ID = "2"
+	if err := db.SetCurrentMatch(ctx, rec); err != nil {
+		t.Fatal(err)
+	}
+	if id := current(); id != "2" {
+		t.Errorf("current match = %q after replacing it", id)
+	}
+	if err := db.ClearCurrentMatch(ctx, "#saltybet"); err != nil {
+		t.Fatal(err)
+	}
+	if id := current(); id != "" {
+		t.Errorf("current match = %q after clearing it", id)
+	}
+}
+
+func TestSQLiteTokens(t *testing.T) {
+	db := openTestStore(t)
+	ctx := context.Background()
+	if blob, err := db.GetToken(ctx, "bot"); err != nil || blob != nil {
+		t.Errorf("missing token = %q, %v", blob, err)
+	}
+	for _, blob := range []string{"one", "two"} {
+		if err := db.PutToken(ctx, "bot", []byte(blob)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.PutToken(ctx, "other", []byte("three")); err != nil {
+		t.Fatal(err)
+	}
+	err := db.RewriteTokens(ctx, func(name string, blob []byte) ([]byte, error) {
+		if name == "other" {
+			return nil, nil
+		}
+		return append([]byte("new "), blob...), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"bot": "new two", "other": "three"} {
+		if blob, err := db.GetToken(ctx, name); err != nil || !bytes.Equal(blob, []byte(want)) {
+			t.Errorf("token %s = %q, %v, want %q", name, blob, err, want)
+		}
+	}
+}
+
+func TestSQLiteUnparsed(t *testing.T) {
+	s := openTestStore(t)
+	prev := db
+	db = s
+	defer func() { db = prev }()
+	base := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i, l := range []struct{ channel, text string }{
+		{"#saltybet", "Exhibition: Ryu vs Ken!"},
+		{"#saltybet", "Exhibition: Big Boss Man vs Guile!"},
+		{"#other", "Exhibition: Ryu vs Ken!"},
+		{"#saltybet", "something else"},
+	} {
+		if err := recordUnparsed(base.Add(time.Duration(i)*time.Minute), l.channel, "bot", l.text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lines, err := s.TopUnparsed(context.Background(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+	l := lines[0]
+	if l.Channel != "#saltybet" || l.Count != 2 || l.Text != "Exhibition: Big Boss Man vs Guile!" ||
+		!l.FirstSeen.Equal(base) || !l.LastSeen.Equal(base.Add(time.Minute)) {
+		t.Errorf("top line = %+v", l)
+	}
+	// ties go to the most recently seen
+	if l := lines[1]; l.Channel != "#saltybet" || l.Template != "something else" || l.Count != 1 {
+		t.Errorf("second line = %+v", l)
+	}
+}
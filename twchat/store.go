@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Store holds everything twchat persists
+type Store interface {
+	Ping(ctx context.Context) error
+	CheckSchema() error
+	Migrate() error
+
+	// InsertMatch records a finished match, returning false if a match with
+	// the same ID was already recorded
+	InsertMatch(ctx context.Context, m storedMatch) (bool, error)
+	SetCurrentMatch(ctx context.Context, rec matchRecord) error
+	ClearCurrentMatch(ctx context.Context, channel string) error
+
+	// GetToken returns the stored blob or nil if there is none
+	GetToken(ctx context.Context, name string) ([]byte, error)
+	PutToken(ctx context.Context, name string, blob []byte) error
+	// RewriteTokens passes every stored token to fn and saves the non-nil
+	// results, all in one transaction
+	RewriteTokens(ctx context.Context, fn func(name string, blob []byte) ([]byte, error)) error
+
+	RecordUnparsed(ctx context.Context, line unparsedLine) error
+	TopUnparsed(ctx context.Context, limit int) ([]unparsedLine, error)
+
+	Matches(ctx context.Context, f historyFilter) (matchPage, error)
+	CharRecord(ctx context.Context, f historyFilter, name, opponent string) (charRecord, error)
+	TierSummaries(ctx context.Context, f historyFilter) ([]tierSummary, error)
+}
+
+// storedMatch is a row in the matches table
+type storedMatch struct {
+	ID, Channel     string
+	Time            time.Time
+	Winner, Loser   string
+	WinPot, LosePot int64
+	Duration        int
+	Tier, Mode      string
+	Outcome, Reason string
+}
+
+type unparsedLine struct {
+	Channel, Template   string
+	FirstSeen, LastSeen time.Time
+	Nick, Text          string
+	Count               int64
+}
+
+// rowScanner is the part of a result set shared by pgx and database/sql
+type rowScanner interface {
+	Next() bool
+	Scan(dest ...interface{}) error
+	Err() error
+}
+
+var db Store
+
+// connectDB opens the embedded database if a sqlite path is configured, and
+// Postgres otherwise
+func connectDB() (err error) {
+	if path := viper.GetString("sqlite"); path != "" {
+		db, err = openSQLite(path)
+	} else {
+		db, err = connectPG()
+	}
+	return
+}
+
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// tokens are stored as a version byte, the nonce and then the sealed JSON
+const tokenBlobVersion = 1
+
+var (
+	tokenKey     cipher.AEAD
+	tokenOldKeys []cipher.AEAD
+
+	errTokenKey = errors.New("unable to decrypt stored token, token_key is wrong or the token is corrupt")
+)
+
+// loadTokenKeys reads the current encryption key and any previous keys still
+// being rotated out. Keys are 32 bytes, encoded as hex or base64.
+func loadTokenKeys() error {
+	var err error
+	tokenKey, err = parseTokenKey(viper.GetString("token_key"))
+	if err != nil {
+		return fmt.Errorf("token_key: %s", err)
+	}
+	for i, encoded := range viper.GetStringSlice("token_old_keys") {
+		key, err := parseTokenKey(encoded)
+		if err != nil {
+			return fmt.Errorf("token_old_keys[%d]: %s", i, err)
+		}
+		tokenOldKeys = append(tokenOldKeys, key)
+	}
+	return nil
+}
+
+func parseTokenKey(encoded string) (cipher.AEAD, error) {
+	if encoded == "" {
+		return nil, errors.New("key is not set")
+	}
+	key, err := hex.DecodeString(encoded)
+	if err != nil {
+		key, err = base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.New("key must be hex or base64")
+		}
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealToken encrypts a token blob with the current key. The row name is
+// authenticated so a blob can't be moved to another row.
+func sealToken(name string, plain []byte) ([]byte, error) {
+	if tokenKey == nil {
+		return nil, errors.New("token_key is not loaded")
+	}
+	nonce := make([]byte, tokenKey.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	blob := append([]byte{tokenBlobVersion}, nonce...)
+	return tokenKey.Seal(blob, nonce, plain, []byte(name)), nil
+}
+
+// openToken decrypts a stored blob, trying old keys if the current one
+// fails. current is false if the blob needs to be re-encrypted.
+func openToken(name string, blob []byte) (plain []byte, current bool, err error) {
+	if len(blob) > 0 && blob[0] == '{' {
+		// stored before encryption was added
+		return blob, false, nil
+	} else if len(blob) == 0 || blob[0] != tokenBlobVersion {
+		return nil, false, errTokenKey
+	}
+	for i, key := range append([]cipher.AEAD{tokenKey}, tokenOldKeys...) {
+		if key == nil || len(blob) < 1+key.NonceSize() {
+			continue
+		}
+		nonce := blob[1 : 1+key.NonceSize()]
+		plain, err := key.Open(nil, nonce, blob[1+key.NonceSize():], []byte(name))
+		if err == nil {
+			return plain, i == 0, nil
+		}
+	}
+	return nil, false, errTokenKey
+}
+
+// rotateTokens re-encrypts any stored tokens that are in plaintext or sealed
+// with an old key
+func rotateTokens() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return db.RewriteTokens(ctx, func(name string, blob []byte) ([]byte, error) {
+		plain, current, err := openToken(name, blob)
+		if err != nil {
+			return nil, fmt.Errorf("token %q: %s", name, err)
+		} else if current {
+			return nil, nil
+		}
+		log.Printf("re-encrypting stored token %q", name)
+		return sealToken(name, plain)
+	})
+}
+
+// rewriteRows collects the tokens rewritten by fn from (name, token) rows
+func rewriteRows(rows rowScanner, fn func(name string, blob []byte) ([]byte, error)) (map[string][]byte, error) {
+	updated := make(map[string][]byte)
+	for rows.Next() {
+		var name string
+		var blob []byte
+		if err := rows.Scan(&name, &blob); err != nil {
+			return nil, err
+		}
+		newBlob, err := fn(name, blob)
+		if err != nil {
+			return nil, err
+		} else if newBlob != nil {
+			updated[name] = newBlob
+		}
+	}
+	return updated, rows.Err()
+}
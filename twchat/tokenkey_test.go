@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"testing"
+)
+
+const (
+	testKeyHex    = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	testOldKeyB64 = "ICEiIyQlJicoKSorLC0uLzAxMjM0NTY3ODk6Ozw9Pj8="
+)
+
+func TestParseTokenKey(t *testing.T) {
+	for _, c := range []struct {
+		name, encoded string
+		ok            bool
+	}{
+		{"hex", testKeyHex, true},
+		{"base64", testOldKeyB64, true},
+		{"empty", "", false},
+		{"short", "0001020304", false},
+		{"garbage", "not a key!", false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := parseTokenKey(c.encoded)
+			if (err == nil) != c.ok {
+				t.Errorf("parseTokenKey(%q) error = %v", c.encoded, err)
+			}
+		})
+	}
+}
+
+func TestSealOpenToken(t *testing.T) {
+	defer func(key cipher.AEAD, old []cipher.AEAD) { tokenKey, tokenOldKeys = key, old }(tokenKey, tokenOldKeys)
+	oldKey, err := parseTokenKey(testOldKeyB64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := parseTokenKey(testKeyHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := []byte(`{"access_token":"abc"}`)
+
+	tokenKey = oldKey
+	sealedOld, err := sealToken("twitch", plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenKey, tokenOldKeys = newKey, nil
+	sealedNew, err := sealToken("twitch", plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name    string
+		oldKeys bool
+		row     string
+		blob    []byte
+		ok      bool
+		current bool
+	}{
+		{"current key", false, "twitch", sealedNew, true, true},
+		{"old key not configured", false, "twitch", sealedOld, false, false},
+		{"old key", true, "twitch", sealedOld, true, false},
+		{"moved to another row", true, "other", sealedNew, false, false},
+		{"plaintext from before encryption", false, "twitch", plain, true, false},
+		{"tampered", true, "twitch", append(append([]byte(nil), sealedNew[:len(sealedNew)-1]...), sealedNew[len(sealedNew)-1]^1), false, false},
+		{"unknown version", true, "twitch", append([]byte{2}, sealedNew[1:]...), false, false},
+		{"empty", true, "twitch", nil, false, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			tokenOldKeys = nil
+			if c.oldKeys {
+				tokenOldKeys = []cipher.AEAD{oldKey}
+			}
+			got, current, err := openToken(c.row, c.blob)
+			if (err == nil) != c.ok {
+				t.Fatalf("openToken error = %v", err)
+			}
+			if c.ok && (!bytes.Equal(got, plain) || current != c.current) {
+				t.Errorf("openToken = %q, %v, want %q, %v", got, current, plain, c.current)
+			}
+		})
+	}
+}
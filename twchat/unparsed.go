@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	templateQuoted  = regexp.MustCompile(`"[^"]*"|“[^”]*”`)
+	templateMention = regexp.MustCompile(`@\w+`)
+	// names on either side of "vs" or after "by", each running to the
+	// nearest punctuation
+	templateVs     = regexp.MustCompile(`(^|[:!?.(] )[^:!?.()]+? (vs\.?|versus) [^:!?.(),]*[^:!?.(),\s]`)
+	templateBy     = regexp.MustCompile(`\bby [^:!?.(),]*[^:!?.(),\s]`)
+	templateNumber = regexp.MustCompile(`[0-9][0-9,.]*`)
+	templateSpace  = regexp.MustCompile(`\s+`)
+)
+
+// lineTemplate normalizes a line so that messages differing only in numbers,
+// quoted text, mentions or the names around "vs" and after "by" are counted
+// together
+func lineTemplate(text string) string {
+	t := templateQuoted.ReplaceAllString(text, `"*"`)
+	t = templateMention.ReplaceAllString(t, "@*")
+	t = templateVs.ReplaceAllString(t, "$1* $2 *")
+	t = templateBy.ReplaceAllString(t, "by *")
+	t = templateNumber.ReplaceAllString(t, "#")
+	t = templateSpace.ReplaceAllString(t, " ")
+	return strings.TrimSpace(t)
+}
+
+const (
+	upsertUnparsed = `INSERT INTO unparsed_lines (channel, template, first_seen, last_seen, nick, text, count) VALUES ($1, $2, $3, $3, $4, $5, 1)
+		ON CONFLICT (channel, template) DO UPDATE SET last_seen = $3, nick = $4, text = $5, count = unparsed_lines.count + 1`
+	selectUnparsed = "SELECT channel, template, first_seen, last_seen, nick, text, count FROM unparsed_lines ORDER BY count DESC, last_seen DESC LIMIT $1"
+)
+
+func scanUnparsed(rows rowScanner) ([]unparsedLine, error) {
+	var lines []unparsedLine
+	for rows.Next() {
+		var l unparsedLine
+		if err := rows.Scan(&l.Channel, &l.Template, &l.FirstSeen, &l.LastSeen, &l.Nick, &l.Text, &l.Count); err != nil {
+			return nil, err
+		}
+		lines = append(lines, l)
+	}
+	return lines, rows.Err()
+}
+
+// recordUnparsed stores a bot line that didn't match any known format
+func recordUnparsed(ts time.Time, channel, nick, text string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return db.RecordUnparsed(ctx, unparsedLine{
+		Channel:  channel,
+		Template: lineTemplate(text),
+		LastSeen: ts,
+		Nick:     nick,
+		Text:     text,
+	})
+}
+
+// reportUnparsed prints the most frequent unrecognized line templates
+func reportUnparsed(args []string) error {
+	limit := 25
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid limit %q", args[0])
+		}
+		limit = n
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	lines, err := db.TopUnparsed(ctx, limit)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "COUNT\tLAST SEEN\tCHANNEL\tNICK\tTEMPLATE\tEXAMPLE")
+	for _, l := range lines {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%q\t%q\n", l.Count, l.LastSeen.Local().Format("2006-01-02 15:04"), l.Channel, l.Nick, l.Template, l.Text)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestLineTemplate(t *testing.T) {
+	for _, c := range []struct {
+		text, want string
+	}{
+		{"Bets are locked. Foo (5) - $1,234,567", "Bets are locked. Foo (#) - $#"},
+		{"Current pot: 12.5k", "Current pot: #k"},
+		{"  lots   of\tspace  ", "lots of space"},
+		{"no numbers here", "no numbers here"},
+		{`"Mr. Foo" was added by @someone`, `"*" was added by *`},
+		{"thanks @someone for the bits", "thanks @* for the bits"},
+		{"Exhibition: Foo Bar vs Baz! Good luck", "Exhibition: * vs *! Good luck"},
+		{"Next up Foo vs. Bar (requested by Qux)", "* vs. * (requested by *)"},
+		{"", ""},
+	} {
+		if got := lineTemplate(c.text); got != c.want {
+			t.Errorf("lineTemplate(%q) = %q, want %q", c.text, got, c.want)
+		}
+	}
+	// lines that only differ in numbers are counted together
+	if a, b := lineTemplate("Team Red wins 3 of 5"), lineTemplate("Team Red wins 12 of 13"); a != b {
+		t.Errorf("templates differ: %q and %q", a, b)
+	}
+	// and so are lines that only differ in the characters' names
+	if a, b := lineTemplate("Exhibition: Ryu vs Ken!"), lineTemplate("Exhibition: Big Boss Man vs Ken Masters!"); a != b {
+		t.Errorf("templates differ: %q and %q", a, b)
+	}
+}